@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// commands are the subcommands of hose, e.g. "hose hosts list".
+// Each one receives the arguments that follow its name.
+var commands = map[string]func(args []string) error{
+	"config":              configCmd,
+	"export-identity":     exportIdentity,
+	"fetch":               fetch,
+	"handshake":           handshakeCmd,
+	"hosts":               hostsCmd,
+	"import-identity":     importIdentity,
+	"import-introduction": importIntroduction,
+	"inbox":               inboxCmd,
+	"introduce":           introduce,
+	"mailbox":             mailboxCmd,
+	"sync":                syncCmd,
+	"invite":              inviteCmd,
+	"peers":               peers,
+	"queue":               queueCmd,
+	"relay":               relayCmd,
+	"whoami":              whoami,
+}
+
+// runCommand runs the subcommand named by the first argument.
+func runCommand(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+	return cmd(args[1:])
+}
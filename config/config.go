@@ -0,0 +1,243 @@
+// Package config reads the configuration file of hose.
+//
+// The file is a subset of TOML: "key = value" lines, where the value is a quoted string, an integer or a boolean,
+// "#" comments, and "[hosts.<name>]" sections that apply to one remote host.
+// Settings that precede the first section are global defaults. For example:
+//
+//	port = 60321
+//	relay = "relay.example.com"
+//	queue = "24h"
+//
+//	[hosts.bob]
+//	address = "bob.example.com"
+//	port = 7000
+//
+// Hostnames that contain dots are quoted: [hosts."bob.example.com"].
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/adrg/xdg"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables that override settings,
+// e.g. HOSE_PORT overrides "port" and HOSE_HANDSHAKE_PORT overrides "handshake-port".
+const EnvPrefix = "HOSE_"
+
+// hostsTable is the name of the table whose sections apply to remote hosts.
+const hostsTable = "hosts"
+
+// Setting is a key and value in the configuration file.
+type Setting struct {
+	Key   string
+	Value string // value without quotes.
+	Line  int    // line number in the file.
+}
+
+// Config is the contents of a configuration file.
+type Config struct {
+	Path   string
+	Global []Setting            // global defaults.
+	Hosts  map[string][]Setting // settings of remote hosts, by name.
+}
+
+// Path returns the path of the configuration file: $HOSE_CONFIG if it is set,
+// or else $XDG_CONFIG_HOME/hose/config.
+func Path() string {
+	if path := os.Getenv(EnvPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(xdg.ConfigHome, "hose", "config")
+}
+
+// Env returns the value of the environment variable that overrides a setting, if it is set.
+func Env(key string) (string, bool) {
+	return os.LookupEnv(EnvName(key))
+}
+
+// EnvName returns the name of the environment variable that overrides a setting.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// Load reads a configuration file. A file that does not exist is an empty configuration.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{Path: path, Hosts: make(map[string][]Setting)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// Read parses a configuration file.
+// Errors are prefixed with the line number, e.g. "3: missing value".
+func Read(r io.Reader) (*Config, error) {
+	cfg := &Config{Hosts: make(map[string][]Setting)}
+	host, inHost := "", false
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name, err := parseSection(line)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", n, err)
+			}
+			if _, ok := cfg.Hosts[name]; ok {
+				return nil, fmt.Errorf("%d: duplicate section for host %q", n, name)
+			}
+			cfg.Hosts[name] = nil
+			host, inHost = name, true
+			continue
+		}
+		setting, err := parseSetting(line)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", n, err)
+		}
+		setting.Line = n
+		section := &cfg.Global
+		if inHost {
+			settings := cfg.Hosts[host]
+			section = &settings
+		}
+		if _, ok := Lookup(*section, setting.Key); ok {
+			return nil, fmt.Errorf("%d: duplicate key %q", n, setting.Key)
+		}
+		*section = append(*section, setting)
+		if inHost {
+			cfg.Hosts[host] = *section
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Lookup returns the value of a key in a list of settings.
+func Lookup(settings []Setting, key string) (string, bool) {
+	for _, s := range settings {
+		if s.Key == key {
+			return s.Value, true
+		}
+	}
+	return "", false
+}
+
+// parseSection parses a section header of the form [hosts.<name>] and returns the name of the host.
+func parseSection(line string) (string, error) {
+	header, ok := strings.CutSuffix(strings.TrimSpace(stripComment(line)), "]")
+	if !ok {
+		return "", fmt.Errorf("malformed section header %q", line)
+	}
+	header = strings.TrimSpace(strings.TrimPrefix(header, "["))
+	table, name, ok := strings.Cut(header, ".")
+	if !ok || strings.TrimSpace(table) != hostsTable {
+		return "", fmt.Errorf("unknown section %q; expected [%s.<name>]", header, hostsTable)
+	}
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, `"`) || strings.HasPrefix(name, "'") {
+		return parseString(name)
+	} else if name == "" || strings.ContainsAny(name, ". \t") {
+		return "", fmt.Errorf("malformed host name %q; quote names that contain dots", name)
+	}
+	return name, nil
+}
+
+// parseSetting parses a line of the form key = value.
+func parseSetting(line string) (Setting, error) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return Setting{}, fmt.Errorf("expected key = value")
+	}
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, " \t\"'") {
+		return Setting{}, fmt.Errorf("malformed key %q", key)
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'") {
+		s, err := parseString(value)
+		if err != nil {
+			return Setting{}, fmt.Errorf("%s: %v", key, err)
+		}
+		return Setting{Key: key, Value: s}, nil
+	}
+	value = strings.TrimSpace(stripComment(value))
+	if value == "" {
+		return Setting{}, fmt.Errorf("%s: missing value", key)
+	} else if value != "true" && value != "false" {
+		if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64); err != nil {
+			return Setting{}, fmt.Errorf("%s: value %q is not a string, integer or boolean; quote strings", key, value)
+		}
+		value = strings.ReplaceAll(value, "_", "")
+	}
+	return Setting{Key: key, Value: value}, nil
+}
+
+// parseString parses a quoted string, followed by an optional comment.
+// Double-quoted strings may contain escape sequences; single-quoted (literal) strings may not.
+func parseString(s string) (string, error) {
+	quote := s[0]
+	end := -1
+	for i := 1; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++ // skip the escaped character.
+		} else if s[i] == quote {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	str, rest := s[:end+1], strings.TrimSpace(s[end+1:])
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after string", rest)
+	}
+	if quote == '\'' {
+		return str[1 : len(str)-1], nil
+	}
+	return strconv.Unquote(str)
+}
+
+// stripComment removes a comment from the end of an unquoted value.
+func stripComment(s string) string {
+	s, _, _ = strings.Cut(s, "#")
+	return s
+}
+
+// Quote formats a value as it would appear in the configuration file:
+// integers and booleans as they are, and everything else as a quoted string.
+func Quote(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	} else if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// QuoteName formats the name of a host as it would appear in a section header.
+func QuoteName(name string) string {
+	if name == "" || strings.ContainsAny(name, ". \t\"'") {
+		return strconv.Quote(name)
+	}
+	return name
+}
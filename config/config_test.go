@@ -0,0 +1,176 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		global []Setting
+		hosts  map[string][]Setting
+	}{
+		{"empty", "", nil, map[string][]Setting{}},
+		{"comments and blank lines", "# comment\n\n   # indented comment\n\t\n", nil, map[string][]Setting{}},
+		{
+			"values",
+			"port = 60321\nrelay = \"relay.example.com\"\ntofu = true\nbig = 1_000_000\n",
+			[]Setting{{"port", "60321", 1}, {"relay", "relay.example.com", 2}, {"tofu", "true", 3}, {"big", "1000000", 4}},
+			map[string][]Setting{},
+		},
+		{
+			"trailing comments",
+			"port = 60321 # comment\nrelay = \"a#b\" # comment\nqueue = 'c#d'#comment\n",
+			[]Setting{{"port", "60321", 1}, {"relay", "a#b", 2}, {"queue", "c#d", 3}},
+			map[string][]Setting{},
+		},
+		{
+			"quoting",
+			"a = \"tab\\there\"\nb = 'C:\\path'\nc = \"quote \\\" inside\"\nd = \"\"\ne = '  spaces  '\n",
+			[]Setting{{"a", "tab\there", 1}, {"b", `C:\path`, 2}, {"c", `quote " inside`, 3}, {"d", "", 4}, {"e", "  spaces  ", 5}},
+			map[string][]Setting{},
+		},
+		{
+			"whitespace",
+			"  port=7000  \n\tqueue\t=\t\"24h\"\t\n",
+			[]Setting{{"port", "7000", 1}, {"queue", "24h", 2}},
+			map[string][]Setting{},
+		},
+		{
+			"host sections",
+			"port = 60321\n\n[hosts.bob]\naddress = \"bob.example.com\"\nport = 7000\n\n[ hosts . \"carol.example.com\" ]\ntofu = true\n[hosts.'dave']\n[hosts.\"\"]\n",
+			[]Setting{{"port", "60321", 1}},
+			map[string][]Setting{
+				"bob":               {{"address", "bob.example.com", 4}, {"port", "7000", 5}},
+				"carol.example.com": {{"tofu", "true", 8}},
+				"dave":              nil,
+				"":                  nil,
+			},
+		},
+		{
+			"same key in different sections",
+			"port = 1\n[hosts.a]\nport = 2\n[hosts.b]\nport = 3\n",
+			[]Setting{{"port", "1", 1}},
+			map[string][]Setting{"a": {{"port", "2", 3}}, "b": {{"port", "3", 5}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := Read(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.Global, test.global) {
+				t.Errorf("global settings = %v; want %v", cfg.Global, test.global)
+			}
+			if !reflect.DeepEqual(cfg.Hosts, test.hosts) {
+				t.Errorf("host settings = %v; want %v", cfg.Hosts, test.hosts)
+			}
+		})
+	}
+}
+
+func TestReadSectionComments(t *testing.T) {
+	headers := []string{
+		"[hosts.bob] # comment",
+		"[hosts.bob]# comment",
+		"[hosts.bob]\t#\tcomment",
+		"  [ hosts.\"bob\" ]   # comment",
+	}
+	for _, header := range headers {
+		cfg, err := Read(strings.NewReader(header + "\nport = 7000\n"))
+		if err != nil {
+			t.Errorf("Read(%q): %v", header, err)
+			continue
+		}
+		if port, ok := Lookup(cfg.Hosts["bob"], "port"); !ok || port != "7000" {
+			t.Errorf("Read(%q) = sections %v; want bob with port 7000", header, cfg.Hosts)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string // expected prefix of the error.
+	}{
+		{"no equals sign", "port 60321\n", "1: expected key = value"},
+		{"missing value", "# comment\nport =\n", "2: port: missing value"},
+		{"comment as value", "port = # comment\n", "1: port: missing value"},
+		{"missing key", "= 1\n", "1: malformed key"},
+		{"quoted key", "\"port\" = 1\n", "1: malformed key"},
+		{"key with space", "handshake port = 1\n", "1: malformed key"},
+		{"unquoted string", "relay = relay.example.com\n", "1: relay: value \"relay.example.com\" is not a string"},
+		{"unterminated string", "relay = \"relay\n", "1: relay: unterminated string"},
+		{"unterminated literal string", "relay = 'relay\n", "1: relay: unterminated string"},
+		{"text after string", "relay = \"a\" b\n", "1: relay: unexpected \"b\" after string"},
+		{"bad escape", "relay = \"\\q\"\n", "1: relay: "},
+		{"duplicate key", "port = 1\nport = 2\n", "2: duplicate key \"port\""},
+		{"duplicate key in section", "[hosts.bob]\nport = 1\nport = 2\n", "3: duplicate key \"port\""},
+		{"duplicate section", "[hosts.bob]\n[hosts.bob]\n", "2: duplicate section for host \"bob\""},
+		{"duplicate quoted section", "[hosts.bob]\n[hosts.\"bob\"]\n", "2: duplicate section for host \"bob\""},
+		{"unknown table", "[peers.bob]\n", "1: unknown section"},
+		{"no host name", "[hosts]\n", "1: unknown section"},
+		{"empty host name", "[hosts.]\n", "1: malformed host name"},
+		{"unquoted dot", "[hosts.bob.example.com]\n", "1: malformed host name"},
+		{"unclosed header", "[hosts.bob\n", "1: malformed section header"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(test.input))
+			if err == nil {
+				t.Fatalf("Read succeeded; want error %q", test.err)
+			} else if !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("Read error = %q; want prefix %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	// A quoted value must read back as the same value.
+	values := []string{"", "60321", "-1", "true", "false", "24h", "relay.example.com", "a # b", `back\slash`, `"quoted"`, "tab\t", "True"}
+	for _, value := range values {
+		input := "key = " + Quote(value) + "\n"
+		cfg, err := Read(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("Read(%q): %v", input, err)
+			continue
+		}
+		if got, _ := Lookup(cfg.Global, "key"); got != value {
+			t.Errorf("Read(%q) = %q; want %q", input, got, value)
+		}
+	}
+}
+
+func TestQuoteName(t *testing.T) {
+	names := []string{"bob", "bob.example.com", "", "a b", "it's", `say "hi"`, "fe80::1%eth0"}
+	for _, name := range names {
+		input := "[hosts." + QuoteName(name) + "]\n"
+		cfg, err := Read(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("Read(%q): %v", input, err)
+			continue
+		}
+		if _, ok := cfg.Hosts[name]; !ok || len(cfg.Hosts) != 1 {
+			t.Errorf("Read(%q) = sections %v; want %q", input, cfg.Hosts, name)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct{ key, env string }{
+		{"port", "HOSE_PORT"},
+		{"handshake-port", "HOSE_HANDSHAKE_PORT"},
+		{"datadir", "HOSE_DATADIR"},
+	}
+	for _, test := range tests {
+		if got := EnvName(test.key); got != test.env {
+			t.Errorf("EnvName(%q) = %q; want %q", test.key, got, test.env)
+		}
+	}
+}
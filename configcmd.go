@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"git.samanthony.xyz/hose/config"
+	"git.samanthony.xyz/hose/hosts"
+)
+
+const configUsage = "Usage: hose config [host]"
+
+// settings are the flags that can also be set in the configuration file and by environment variables.
+var settings = []string{
+	"identity", "datadir", "port", "handshake-port", "bind", "interface",
+	"relay", "mailbox", "queue", "timeout", "tofu", "advertise", "fingerprint",
+}
+
+// hostSettings are the settings that can be set for a remote host in the configuration file.
+// "address" and "port" are where to connect to the host; the others override the global settings.
+// There are no compression or output directory settings: transfers are not compressed,
+// and received data is written to stdout or to the inbox, not to a directory.
+var hostSettings = []string{"address", "port", "relay", "mailbox", "queue", "timeout", "tofu"}
+
+// source is where the value of a setting comes from. Later sources take precedence over earlier ones.
+type source int
+
+const (
+	sourceDefault source = iota
+	sourceConfig
+	sourceHost
+	sourceEnv
+	sourceFlag
+)
+
+func (src source) String() string {
+	switch src {
+	case sourceDefault:
+		return "default"
+	case sourceConfig:
+		return "config file"
+	case sourceHost:
+		return "host section"
+	case sourceEnv:
+		return "environment"
+	case sourceFlag:
+		return "command line"
+	}
+	panic("unreachable")
+}
+
+var (
+	// cfg is the configuration file.
+	cfg *config.Config
+
+	// settingSources are the sources of the settings that do not have their default values.
+	settingSources = make(map[string]source)
+)
+
+// hostConfig holds the settings of a remote host from the configuration file that are not flags.
+type hostConfig struct {
+	addrs []string // addresses to connect to instead of the known ones, if any.
+	port  uint16   // port to connect to; 0 if it is not set.
+}
+
+// loadConfig applies the configuration file and then the environment variables
+// to the settings that were not given on the command line. It must be called after flag.Parse.
+func loadConfig() error {
+	flag.Visit(func(f *flag.Flag) { settingSources[f.Name] = sourceFlag })
+	var err error
+	cfg, err = config.Load(config.Path())
+	if err != nil {
+		return err
+	}
+	for _, s := range cfg.Global {
+		if !slices.Contains(settings, s.Key) {
+			return fmt.Errorf("%s:%d: unknown setting %q", cfg.Path, s.Line, s.Key)
+		}
+		if err := setSetting(s.Key, s.Value, sourceConfig); err != nil {
+			return fmt.Errorf("%s:%d: %v", cfg.Path, s.Line, err)
+		}
+	}
+	for name, section := range cfg.Hosts {
+		for _, s := range section {
+			if !slices.Contains(hostSettings, s.Key) {
+				return fmt.Errorf("%s:%d: unknown setting %q for host %s", cfg.Path, s.Line, s.Key, name)
+			}
+		}
+	}
+	for _, name := range settings {
+		if value, ok := config.Env(name); ok {
+			if err := setSetting(name, value, sourceEnv); err != nil {
+				return fmt.Errorf("%s: %v", config.EnvName(name), err)
+			}
+		}
+	}
+	return nil
+}
+
+// setSetting sets a flag, unless its value comes from a source that takes precedence.
+func setSetting(name, value string, src source) error {
+	if settingSources[name] > src {
+		return nil
+	}
+	if err := flag.Set(name, value); err != nil {
+		return fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+	}
+	settingSources[name] = src
+	return nil
+}
+
+// applyHostConfig applies the section of a remote host in the configuration file.
+// The section is found by the name that the user refers to the host by, or by the name or aliases of the known host.
+// The returned function restores the global settings.
+func applyHostConfig(name string, host hosts.Host) (hostConfig, func(), error) {
+	var hc hostConfig
+	section, ok := hostSection(name, host)
+	if !ok {
+		return hc, func() {}, nil
+	}
+
+	type saved struct {
+		value string
+		src   source
+		ok    bool
+	}
+	old := make(map[string]saved)
+	restore := func() {
+		for key, s := range old {
+			flag.Set(key, s.value)
+			if s.ok {
+				settingSources[key] = s.src
+			} else {
+				delete(settingSources, key)
+			}
+		}
+		applySettings()
+	}
+
+	for _, s := range cfg.Hosts[section] {
+		var err error
+		switch s.Key {
+		case "address":
+			hc.addrs = splitList(s.Value)
+		case "port":
+			var port uint64
+			port, err = strconv.ParseUint(s.Value, 10, 16)
+			if err == nil && port == 0 {
+				err = errors.New("port 0")
+			}
+			hc.port = uint16(port)
+		default:
+			src, ok := settingSources[s.Key]
+			old[s.Key] = saved{flag.Lookup(s.Key).Value.String(), src, ok}
+			err = setSetting(s.Key, s.Value, sourceHost)
+		}
+		if err != nil {
+			restore()
+			return hostConfig{}, func() {}, fmt.Errorf("%s:%d: %v", cfg.Path, s.Line, err)
+		}
+	}
+	applySettings()
+	return hc, restore, nil
+}
+
+// hostSection returns the name of the section of a remote host in the configuration file, if it has one.
+func hostSection(name string, host hosts.Host) (string, bool) {
+	names := append([]string{name}, host.Names()...)
+	for _, name := range names {
+		if _, ok := cfg.Hosts[name]; ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// configCmd prints the effective configuration, i.e. the value of every setting and where it comes from.
+// With a host, the settings that apply when connecting to that host are printed.
+func configCmd(args []string) error {
+	if len(args) > 1 {
+		return errors.New(configUsage)
+	}
+	fmt.Printf("# %s\n", cfg.Path)
+	if len(args) == 1 {
+		return printHostConfig(args[0])
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	printSettings(w, settings)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cfg.Hosts))
+	for name := range cfg.Hosts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("\n[hosts.%s]\n", config.QuoteName(name))
+		for _, s := range cfg.Hosts[name] {
+			fmt.Printf("%s = %s\n", s.Key, config.Quote(s.Value))
+		}
+	}
+	return nil
+}
+
+// printHostConfig prints the settings that apply when connecting to a remote host,
+// which is given as "host" or "host:port".
+func printHostConfig(target string) error {
+	name, port, err := splitTarget(target)
+	if err != nil {
+		return err
+	}
+	host, err := hosts.Lookup(name)
+	if err != nil && !errors.Is(err, hosts.ErrNoSuchHost) {
+		return err
+	}
+	hc, restore, err := applyHostConfig(name, host)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// The global port is the one that this host listens on, so it does not apply.
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	printSettings(w, slices.DeleteFunc(slices.Clone(settings), func(key string) bool {
+		return key == "port" || !slices.Contains(hostSettings, key)
+	}))
+	addrs, addrsSrc := slices.DeleteFunc(slices.Clone(host.Addrs), hosts.IsHashed), "known hosts"
+	if len(hc.addrs) > 0 {
+		addrs, addrsSrc = hc.addrs, "host section"
+	} else if len(addrs) < 1 {
+		addrs, addrsSrc = []string{name}, "command line"
+	}
+	fmt.Fprintf(w, "address\t= %s\t# %s\n", config.Quote(strings.Join(addrs, ",")), addrsSrc)
+	portSrc := "command line"
+	switch {
+	case port != 0:
+	case hc.port != 0:
+		port, portSrc = hc.port, "host section"
+	case host.Port != 0:
+		port, portSrc = host.Port, "known hosts"
+	default:
+		port, portSrc = defaultPort, "default"
+	}
+	fmt.Fprintf(w, "port\t= %d\t# %s\n", port, portSrc)
+	return w.Flush()
+}
+
+// printSettings prints the values of settings and where they come from.
+func printSettings(w io.Writer, keys []string) {
+	for _, key := range keys {
+		src := settingSources[key]
+		comment := src.String()
+		if src == sourceEnv {
+			comment += " (" + config.EnvName(key) + ")"
+		}
+		fmt.Fprintf(w, "%s\t= %s\t# %s\n", key, config.Quote(flag.Lookup(key).Value.String()), comment)
+	}
+}
@@ -0,0 +1,255 @@
+// Package discovery finds hose hosts on the local network.
+//
+// Hosts that are waiting for a transfer advertise themselves: they answer queries that are sent to a multicast group
+// with their name and the fingerprint of their keys, in the style of mDNS/DNS-SD.
+// Advertisements are not authenticated; they only help to find the address of a host,
+// whose keys are verified as usual when connecting to it.
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/util"
+)
+
+// Port is the UDP port of the multicast group.
+const Port = 60324
+
+// groups are the multicast groups that queries are sent to, so that hosts are found over both IPv4 and IPv6.
+var groups = []struct {
+	network string
+	addr    *net.UDPAddr
+}{
+	{"udp4", &net.UDPAddr{IP: net.IPv4(239, 255, 96, 32), Port: Port}}, // IPv4 organization-local scope.
+	{"udp6", &net.UDPAddr{IP: net.ParseIP("ff02::9632"), Port: Port}},  // IPv6 link-local scope.
+}
+
+// Timeout is how long to wait for answers to a query by default.
+const Timeout = 2 * time.Second
+
+const (
+	protocol = "hose-discovery"
+	version  = 1
+
+	queryType    = "query"
+	announceType = "announce"
+
+	maxMessageLen = 1024
+)
+
+// message is a query or an announcement.
+type message struct {
+	Protocol    string `json:"protocol"`
+	Version     int    `json:"version"`
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`        // name of the announcing host.
+	Fingerprint string `json:"fingerprint,omitempty"` // fingerprint of the announcing host, or of the host that a query is looking for.
+}
+
+// Peer is a host that was found on the local network.
+type Peer struct {
+	Name        string // name that the host announces; may be empty.
+	Fingerprint fingerprint.Fingerprint
+	Addr        netip.Addr // address that the announcement came from.
+}
+
+// Advertiser answers queries for the local host until it is closed.
+type Advertiser struct {
+	conns []*net.UDPConn
+}
+
+// Advertise starts answering queries with the name and fingerprint of the local host.
+// It answers on every multicast group that it can join, e.g. only on the IPv4 group if IPv6 is not available.
+func Advertise(name string, fp fingerprint.Fingerprint) (*Advertiser, error) {
+	announcement, err := json.Marshal(message{protocol, version, announceType, name, fp.String()})
+	if err != nil {
+		return nil, err
+	}
+	var a Advertiser
+	var errs []error
+	for _, group := range groups {
+		conn, err := net.ListenMulticastUDP(group.network, nil, group.addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", group.addr, err))
+			continue
+		}
+		go answer(conn, fp, announcement)
+		util.Logf("advertising on %s", group.addr)
+		a.conns = append(a.conns, conn)
+	}
+	if len(a.conns) < 1 {
+		return nil, errors.Join(errs...)
+	}
+	return &a, nil
+}
+
+// answer answers queries that arrive on a connection until it is closed.
+func answer(conn *net.UDPConn, fp fingerprint.Fingerprint, announcement []byte) {
+	buf := make([]byte, maxMessageLen)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue
+		}
+		var query message
+		if err := json.Unmarshal(buf[:n], &query); err != nil || !query.valid(queryType) {
+			continue
+		}
+		if query.Fingerprint != "" && query.Fingerprint != fp.String() {
+			continue // looking for a different host.
+		}
+		conn.WriteToUDP(announcement, src)
+	}
+}
+
+// Close stops answering queries.
+func (a *Advertiser) Close() error {
+	var errs []error
+	for _, conn := range a.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Browse returns the hosts on the local network that answer a query within the timeout.
+func Browse(timeout time.Duration) ([]Peer, error) {
+	return query(nil, timeout)
+}
+
+// Find looks for the host with the given fingerprint on the local network.
+func Find(fp fingerprint.Fingerprint, timeout time.Duration) (Peer, error) {
+	peers, err := query(&fp, timeout)
+	if err != nil {
+		return Peer{}, err
+	}
+	for _, peer := range peers {
+		if peer.Fingerprint == fp {
+			return peer, nil
+		}
+	}
+	return Peer{}, fmt.Errorf("no host with fingerprint %s answered on the local network", fp)
+}
+
+// query sends a query to the multicast groups and collects the answers until the timeout expires.
+// If fp is not nil, only the host with that fingerprint is asked to answer, and query returns as soon as it does.
+func query(fp *fingerprint.Fingerprint, timeout time.Duration) ([]Peer, error) {
+	q := message{Protocol: protocol, Version: version, Type: queryType}
+	if fp != nil {
+		q.Fingerprint = fp.String()
+	}
+	buf, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query every group that can be reached, e.g. only the IPv4 group if IPv6 is not available.
+	var conns []*net.UDPConn
+	var errs []error
+	for _, group := range groups {
+		conn, err := net.ListenUDP(group.network, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", group.addr, err))
+			continue
+		}
+		defer conn.Close()
+		if _, err := conn.WriteToUDP(buf, group.addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", group.addr, err))
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) < 1 {
+		return nil, errors.Join(errs...)
+	}
+
+	replies := make(chan reply)
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(timeout)
+	for _, conn := range conns {
+		conn.SetReadDeadline(deadline)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readReplies(conn, replies)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(replies)
+	}()
+
+	peers := make([]Peer, 0)
+	errs = nil
+	for r := range replies {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		if !slices.Contains(peers, r.peer) {
+			peers = append(peers, r.peer)
+		}
+		if fp != nil && r.peer.Fingerprint == *fp {
+			// Stop waiting for other answers.
+			for _, conn := range conns {
+				conn.SetReadDeadline(time.Now())
+			}
+		}
+	}
+	return peers, errors.Join(errs...)
+}
+
+// reply is an announcement that answers a query, or an error receiving one.
+type reply struct {
+	peer Peer
+	err  error
+}
+
+// readReplies sends the announcements that arrive on a connection to a channel until the connection's read deadline.
+func readReplies(conn *net.UDPConn, replies chan<- reply) {
+	buf := make([]byte, maxMessageLen)
+	for {
+		n, src, err := conn.ReadFromUDPAddrPort(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		} else if err != nil {
+			replies <- reply{err: err}
+			return
+		}
+		peer, err := parseAnnouncement(buf[:n], src.Addr().Unmap())
+		if err != nil {
+			continue
+		}
+		replies <- reply{peer: peer}
+	}
+}
+
+// parseAnnouncement parses an announcement that came from an address.
+func parseAnnouncement(buf []byte, addr netip.Addr) (Peer, error) {
+	var msg message
+	if err := json.Unmarshal(buf, &msg); err != nil {
+		return Peer{}, err
+	} else if !msg.valid(announceType) {
+		return Peer{}, fmt.Errorf("not an announcement")
+	}
+	fp, err := fingerprint.Parse(msg.Fingerprint)
+	if err != nil {
+		return Peer{}, err
+	}
+	return Peer{msg.Name, fp, addr}, nil
+}
+
+// valid reports whether a message is a message of the discovery protocol of the given type.
+func (msg message) valid(typ string) bool {
+	return msg.Protocol == protocol && msg.Version == version && msg.Type == typ
+}
@@ -0,0 +1,120 @@
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"git.samanthony.xyz/hose/key"
+)
+
+// Size is the length of a fingerprint in bytes.
+const Size = 16
+
+// Fingerprint is a short hash of a host's public keys.
+// Users compare fingerprints to verify that they have received the correct keys.
+type Fingerprint [Size]byte
+
+// Format is a human-readable encoding of a fingerprint.
+// It implements flag.Value.
+type Format int
+
+const (
+	Hex   Format = iota // groups of hexadecimal digits, e.g. "3f2a:9c01:...".
+	Words               // PGP word list, e.g. "crumpled Norwegian ...".
+	Emoji               // one emoji per byte.
+	Art                 // randomart picture, like OpenSSH's VisualHostKey.
+)
+
+var formatNames = []string{"hex", "words", "emoji", "art"}
+
+// Of returns the fingerprint of a host's public keys:
+// the first Size bytes of the SHA-256 hash of the encryption key followed by the signature verification key.
+func Of(boxPubKey key.BoxPublicKey, sigPubKey key.SigPublicKey) Fingerprint {
+	sum := sha256.Sum256(append(boxPubKey[:], sigPubKey[:]...))
+	return Fingerprint(sum[:Size])
+}
+
+// String encodes the fingerprint as groups of four hexadecimal digits separated by colons.
+func (f Fingerprint) String() string {
+	s := hex.EncodeToString(f[:])
+	groups := make([]string, 0, len(s)/4)
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, ":")
+}
+
+// Words encodes the fingerprint with the PGP word list.
+func (f Fingerprint) Words() string {
+	words := make([]string, len(f))
+	for i, b := range f {
+		if i%2 == 0 {
+			words[i] = evenWords[b]
+		} else {
+			words[i] = oddWords[b]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Emoji encodes the fingerprint as one emoji per byte,
+// taken from the Miscellaneous Symbols and Pictographs block (U+1F400 to U+1F4FF).
+func (f Fingerprint) Emoji() string {
+	var b strings.Builder
+	for _, x := range f {
+		b.WriteRune(0x1F400 + rune(x))
+	}
+	return b.String()
+}
+
+// Format encodes the fingerprint in the given format.
+// The Art format spans multiple lines; the others are a single line.
+func (f Fingerprint) Format(format Format) string {
+	switch format {
+	case Words:
+		return f.Words()
+	case Emoji:
+		return f.Emoji()
+	case Art:
+		return f.Art()
+	default:
+		return f.String()
+	}
+}
+
+// Parse parses a fingerprint in the format returned by String.
+func Parse(s string) (Fingerprint, error) {
+	var f Fingerprint
+	b, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil {
+		return f, fmt.Errorf("malformed fingerprint %q: %v", s, err)
+	} else if len(b) != Size {
+		return f, fmt.Errorf("malformed fingerprint %q: expected %d bytes; got %d", s, Size, len(b))
+	}
+	return Fingerprint(b), nil
+}
+
+// Multiline reports whether fingerprints in this format span more than one line.
+func (format Format) Multiline() bool {
+	return format == Art
+}
+
+func (format Format) String() string {
+	if int(format) < len(formatNames) {
+		return formatNames[format]
+	}
+	return fmt.Sprintf("Format(%d)", int(format))
+}
+
+// Set parses the name of a format. It is used by the flag package.
+func (format *Format) Set(name string) error {
+	for i, s := range formatNames {
+		if s == name {
+			*format = Format(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown fingerprint format %q; expected one of %q", name, formatNames)
+}
@@ -0,0 +1,63 @@
+package fingerprint
+
+import "strings"
+
+// Dimensions of a randomart picture.
+const (
+	artWidth  = 17
+	artHeight = 9
+)
+
+// artSymbols are the symbols of a randomart picture, in order of how often a square was visited.
+// 'S' and 'E' mark the start and end of the walk.
+const artSymbols = " .o+=*BOX@%&#/^"
+
+// Art draws the fingerprint as a randomart picture using the "drunken bishop" algorithm of OpenSSH.
+// A bishop starts in the centre of the board and moves diagonally once for each pair of bits in the fingerprint.
+// Each square shows how often the bishop visited it.
+func (f Fingerprint) Art() string {
+	var board [artHeight][artWidth]int
+	x, y := artWidth/2, artHeight/2
+	startX, startY := x, y
+
+	for _, b := range f {
+		for i := 0; i < 4; i++ {
+			if b&0x1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&0x2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = max(0, min(x, artWidth-1))
+			y = max(0, min(y, artHeight-1))
+			if board[y][x] < len(artSymbols)-1 {
+				board[y][x]++
+			}
+			b >>= 2
+		}
+	}
+
+	var s strings.Builder
+	border := "+" + strings.Repeat("-", artWidth) + "+\n"
+	s.WriteString(border)
+	for row := range board {
+		s.WriteByte('|')
+		for col, visits := range board[row] {
+			switch {
+			case col == startX && row == startY:
+				s.WriteByte('S')
+			case col == x && row == y:
+				s.WriteByte('E')
+			default:
+				s.WriteByte(artSymbols[visits])
+			}
+		}
+		s.WriteString("|\n")
+	}
+	s.WriteString(strings.TrimSuffix(border, "\n"))
+	return s.String()
+}
@@ -5,11 +5,15 @@ go 1.23.0
 toolchain go1.23.6
 
 require (
-	github.com/adrg/xdg v0.5.3 // indirect
+	github.com/adrg/xdg v0.5.3
+	github.com/keybase/saltpack v0.0.0-20250124001807-83b98d5a6acc
+	github.com/tonistiigi/units v0.0.0-20180711220420-6950e57a87ea
+	golang.org/x/crypto v0.37.0
+	golang.org/x/sync v0.13.0
+	rsc.io/qr v0.2.0
+)
+
+require (
 	github.com/keybase/go-codec v0.0.0-20180928230036-164397562123 // indirect
-	github.com/keybase/saltpack v0.0.0-20250124001807-83b98d5a6acc // indirect
-	github.com/tonistiigi/units v0.0.0-20180711220420-6950e57a87ea // indirect
-	golang.org/x/crypto v0.37.0 // indirect
-	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 )
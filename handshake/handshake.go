@@ -2,23 +2,76 @@ package handshake
 
 import (
 	"context"
+	"fmt"
 	"golang.org/x/sync/errgroup"
 	"time"
 
+	"git.samanthony.xyz/hose/fingerprint"
+	hose_net "git.samanthony.xyz/hose/net"
 	"git.samanthony.xyz/hose/util"
 )
 
 const (
-	port    = 60322
+	// Port is the TCP port that hosts exchange keys on.
+	Port    = 60322
 	network = "tcp"
 
 	timeout       = 1 * time.Minute
 	retryInterval = 500 * time.Millisecond
 )
 
+// Options configure a handshake.
+type Options struct {
+	Name    string   // name to save the remote host under; if empty, the name it announces.
+	Aliases []string // optional extra names for the remote host.
+	Replace bool     // accept new keys for a known host whose keys have changed.
+
+	// Fingerprint is the format of the fingerprint that the user is asked to verify.
+	Fingerprint fingerprint.Format
+
+	// Expect is the fingerprint that the remote host's keys are expected to have.
+	// If it is not nil, the keys are verified by comparing fingerprints instead of asking the user.
+	Expect *fingerprint.Fingerprint
+
+	// Trust saves the remote host's keys without asking the user to verify them.
+	// The keys are still compared with Expect if it is not nil;
+	// if it is nil and the host was neither introduced nor Authenticated, the keys are saved as trusted on first use.
+	Trust bool
+
+	// Authenticated is true if the remote host's identity was authenticated by other means,
+	// e.g. by the secret of an invitation, so that trusted keys are saved as verified.
+	Authenticated bool
+
+	// IntroducedBy is the chain of introducers that vouched for the remote host's keys, if any.
+	// If it is not empty, the keys are saved as introduced rather than verified.
+	IntroducedBy []fingerprint.Fingerprint
+
+	// Relay is the address of a relay server to go through if the hosts cannot connect directly, if any.
+	// Both hosts must use the same relay server and Channel.
+	Relay   string
+	Channel string
+
+	// Port is the TCP port that both hosts exchange keys on; if it is 0, the default Port is used.
+	Port uint16
+}
+
+// port returns the TCP port to exchange keys on.
+func (opts Options) port() uint16 {
+	if opts.Port == 0 {
+		return Port
+	}
+	return opts.Port
+}
+
 // Handshake exchanges public keys with a remote host.
 // The user is asked to verify the received keys before they are saved in the known hosts file.
-func Handshake(rhost string) error {
+func Handshake(rhost string, opts Options) error {
+	if opts.Relay != "" && opts.Channel == "" {
+		return fmt.Errorf("a channel is required to handshake through a relay")
+	}
+	if addr, err := hose_net.ParseAddr(rhost); err == nil {
+		rhost = addr.String() // without brackets, so that it can be saved as an address.
+	}
 	util.Logf("initiating handshake with %s...", rhost)
 
 	errs := make(chan error, 2)
@@ -26,13 +79,13 @@ func Handshake(rhost string) error {
 
 	group, ctx := errgroup.WithContext(context.Background())
 	group.Go(func() error {
-		if err := send(rhost); err != nil {
+		if err := send(rhost, opts); err != nil {
 			errs <- err
 		}
 		return nil
 	})
 	group.Go(func() error {
-		if err := receive(rhost); err != nil {
+		if err := receive(rhost, opts); err != nil {
 			errs <- err
 		}
 		return nil
@@ -0,0 +1,31 @@
+package handshake
+
+import (
+	"fmt"
+
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/identity"
+)
+
+// Import saves the identity of a remote host that was received out of band,
+// e.g. by scanning the QR code printed by "hose whoami -qr", from an identity bundle, or from an introduction.
+// Unlike Handshake, the remote host does not need to be online.
+// The keys are verified as configured by the options before they are saved in the known hosts file.
+func Import(id identity.Identity, opts Options) error {
+	name := opts.Name
+	if name == "" {
+		name = id.Name
+	}
+	if name == "" {
+		return fmt.Errorf("the identity has no name; choose one with -name")
+	}
+	rHost := id.Host(name)
+	rHost.Aliases = opts.Aliases
+	if len(opts.IntroducedBy) > 0 {
+		rHost.Trust = hosts.Introduced
+		rHost.IntroducedBy = opts.IntroducedBy
+	} else if opts.Trust && opts.Expect == nil && !opts.Authenticated {
+		rHost.Trust = hosts.TOFU // nothing was verified.
+	}
+	return save(rHost, "imported", "", opts)
+}
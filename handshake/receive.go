@@ -3,32 +3,31 @@ package handshake
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
 	"net"
-	"net/netip"
 	"os"
 	"slices"
 	"strings"
 
+	"git.samanthony.xyz/hose/fingerprint"
 	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/identity"
 	"git.samanthony.xyz/hose/key"
 	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/relay"
 	"git.samanthony.xyz/hose/util"
 )
 
-type keyType string
-
-const (
-	boxPublicKey keyType = "Public encryption key"
-	sigPublicKey         = "Public signature verification key"
-)
-
 var errVerifyKey = errors.New("host key verification failed")
 
-// receive receives the public keys of a remote host.
+// stdin is shared by all prompts so that input buffered by one prompt is not lost to the next.
+var stdin = bufio.NewScanner(os.Stdin)
+
+// receive receives the public keys and the announced name of a remote host.
 // The user is asked to verify the keys before they are saved to the known hosts file.
-func receive(rhost string) error {
-	conn, err := hose_net.AcceptConnection(network, port)
+func receive(rhost string, opts Options) error {
+	conn, err := accept(opts)
 	if err != nil {
 		return err
 	}
@@ -39,22 +38,116 @@ func receive(rhost string) error {
 	if err != nil {
 		return err
 	}
-
-	// Ask user to verify the keys.
-	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	announced, err := receiveName(conn)
 	if err != nil {
 		return err
 	}
-	raddr, err := netip.ParseAddr(host)
+
+	// Ask user to verify the keys.
+	name := opts.Name
+	if name == "" {
+		name = chooseName(rhost, announced)
+	}
+	addrs := []string{rhost}
+	where := "via relay"
+	if !relay.Relayed(conn) {
+		raddr, err := hose_net.RemoteAddr(conn)
+		if err != nil {
+			return err
+		}
+		if raddr.String() != rhost {
+			addrs = append(addrs, raddr.String())
+		}
+		where = raddr.String()
+	}
+	rHost := hosts.Host{
+		Name:         name,
+		Aliases:      opts.Aliases,
+		Addrs:        addrs,
+		BoxPublicKey: rBoxPubKey,
+		SigPublicKey: rSigPubKey,
+	}
+
+	return save(rHost, where, rhost, opts)
+}
+
+// accept accepts a connection on the handshake port, or through the relay server if there is one.
+func accept(opts Options) (net.Conn, error) {
+	if opts.Relay == "" {
+		return hose_net.AcceptConnection(network, opts.port())
+	}
+	ln, err := hose_net.Listen(network, opts.port())
 	if err != nil {
+		return nil, err
+	}
+	return relay.Accept(ln, opts.Relay, opts.Channel)
+}
+
+// save verifies the keys of a remote host and saves them in the known hosts file.
+// The keys are compared with the expected fingerprint, if any; otherwise the user is asked to verify them,
+// unless they are trusted. Where describes where the keys came from,
+// and contacted is the name or address that the host was contacted by, if any.
+func save(rHost hosts.Host, where, contacted string, opts Options) error {
+	if err := keepKnownNames(&rHost); err != nil {
 		return err
 	}
-	if err := verifyKeys(raddr, rBoxPubKey, rSigPubKey); err != nil {
+
+	// Refuse to silently replace the keys of a known host.
+	if err := checkKeyChange(rHost, contacted, opts.Replace); err != nil {
 		return err
 	}
 
+	if opts.Expect != nil {
+		if fp := rHost.Fingerprint(); fp != *opts.Expect {
+			return fmt.Errorf("%v: fingerprint of host %q is %s; expected %s", errVerifyKey, rHost.Name, fp, opts.Expect)
+		}
+	} else if !opts.Trust {
+		if err := verifyKeys(rHost, where, opts.Fingerprint); err != nil {
+			return err
+		}
+	}
+
 	// Save in known hosts file.
-	return hosts.Add(hosts.Host{raddr, rBoxPubKey, rSigPubKey})
+	if opts.Replace {
+		return hosts.Replace(rHost)
+	}
+	return hosts.Add(rHost)
+}
+
+// keepKnownNames keeps the aliases and addresses of a known host that is handshaking again.
+// Aliases given by the user replace the known ones.
+func keepKnownNames(rHost *hosts.Host) error {
+	old, err := hosts.Lookup(rHost.Name)
+	if errors.Is(err, hosts.ErrNoSuchHost) || (err == nil && !old.HasName(rHost.Name)) {
+		return nil // new host.
+	} else if err != nil {
+		return err
+	}
+	if len(rHost.Aliases) < 1 {
+		rHost.Aliases = old.Aliases
+	}
+	for _, addr := range old.Addrs {
+		if !slices.Contains(rHost.Addrs, addr) {
+			rHost.Addrs = append(rHost.Addrs, addr)
+		}
+	}
+	return nil
+}
+
+// checkKeyChange warns the user if the keys of a known host have changed,
+// i.e. if a known host with the same name, or at the address that was contacted, has different keys.
+// It returns a non-nil error if they have changed and replace is false.
+func checkKeyChange(rHost hosts.Host, contacted string, replace bool) error {
+	err := hosts.CheckKeys(rHost, contacted)
+	var keyChange *hosts.KeyChangeError
+	if !errors.As(err, &keyChange) {
+		return err
+	}
+	util.Logf("%s", keyChange.Warning())
+	if !replace {
+		return fmt.Errorf("%v; use -replace to accept the new keys", err)
+	}
+	return nil
 }
 
 func receiveKeys(conn net.Conn) (key.BoxPublicKey, key.SigPublicKey, error) {
@@ -77,23 +170,52 @@ func receiveKeys(conn net.Conn) (key.BoxPublicKey, key.SigPublicKey, error) {
 	return rBoxPubKey, rSigPubKey, nil
 }
 
-// verifyKeys asks the user to verify keys received from a remote host.
-// It returns a non-nil error if the user rejects the keys.
-func verifyKeys(host netip.Addr, rBoxPubKey key.BoxPublicKey, rSigPubKey key.SigPublicKey) error {
-	// Verify box key.
-	if err := verifyKey(host, rBoxPubKey[:], boxPublicKey); err != nil {
-		return err
+// receiveName receives the name that a remote host announces after its keys.
+// Hosts that do not announce a name close the connection after sending their keys;
+// in that case, or if the announced name is invalid, "" is returned.
+func receiveName(conn net.Conn) (string, error) {
+	buf, err := io.ReadAll(io.LimitReader(conn, identity.MaxNameLen+1))
+	if err != nil {
+		return "", err
+	}
+	name := string(buf)
+	if name == "" {
+		return "", nil
+	} else if !identity.ValidName(name) {
+		util.Logf("ignoring invalid name announced by %s", conn.RemoteAddr())
+		return "", nil
 	}
-	// Verify signature verification key.
-	return verifyKey(host, rSigPubKey[:], sigPublicKey)
+	util.Logf("%s announced name %q", conn.RemoteAddr(), name)
+	return name, nil
 }
 
-// verifyKey asks the user to verify a key received from a remote host.
-// It returns a non-nil error if the user rejects the key.
-func verifyKey(host netip.Addr, key []byte, kt keyType) error {
-	// Ask host to verify the key.
-	util.Logf("%s key of host %q: %x\nIs this the correct key (yes/[no])?",
-		kt, host, key[:])
+// chooseName chooses the name to save a remote host under if the user did not choose one.
+// It is the name announced by the host, or else the name that the host was contacted by.
+// If the announced name belongs to a known host, it is used even if the keys differ,
+// so that the key change is caught when the host is saved.
+func chooseName(rhost, announced string) string {
+	if announced == "" {
+		return rhost
+	}
+	host, err := hosts.Lookup(announced)
+	if errors.Is(err, hosts.ErrNoSuchHost) || (err == nil && host.HasName(announced)) {
+		return announced
+	}
+	util.Logf("name %q announced by %s is the address of a known host; using %q instead", announced, rhost, rhost)
+	return rhost
+}
+
+// verifyKeys asks the user to verify the fingerprint of the keys received from a remote host.
+// It returns a non-nil error if the user rejects the keys.
+func verifyKeys(rHost hosts.Host, where string, format fingerprint.Format) error {
+	util.Logf("Public encryption key of host %q (%s): %x", rHost.Name, where, rHost.BoxPublicKey)
+	util.Logf("Public signature verification key of host %q (%s): %x", rHost.Name, where, rHost.SigPublicKey)
+	fp := rHost.Fingerprint().Format(format)
+	if format.Multiline() {
+		fp = "\n" + fp
+	}
+	util.Logf("Fingerprint of host %q (%s): %s\nIs this the correct fingerprint (yes/[no])?",
+		rHost.Name, where, fp)
 	response, err := scan([]string{"yes", "no", ""})
 	if err != nil {
 		return err
@@ -111,19 +233,18 @@ func verifyKey(host netip.Addr, key []byte, kt keyType) error {
 
 // scan reads from stdin until the user enters one of the valid responses.
 func scan(responses []string) (string, error) {
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	if err := scanner.Err(); err != nil {
+	stdin.Scan()
+	if err := stdin.Err(); err != nil {
 		return "", err
 	}
-	response := strings.TrimSpace(scanner.Text())
+	response := strings.TrimSpace(stdin.Text())
 	for !slices.Contains(responses, response) {
 		util.Logf("Please enter one of %q", responses)
-		scanner.Scan()
-		if err := scanner.Err(); err != nil {
+		stdin.Scan()
+		if err := stdin.Err(); err != nil {
 			return "", err
 		}
-		response = strings.TrimSpace(scanner.Text())
+		response = strings.TrimSpace(stdin.Text())
 	}
 	return response, nil
 }
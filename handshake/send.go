@@ -6,19 +6,24 @@ import (
 	"net"
 	"time"
 
+	"git.samanthony.xyz/hose/identity"
+
 	"git.samanthony.xyz/hose/key"
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/relay"
 	"git.samanthony.xyz/hose/util"
 )
 
-// send sends the local public box (encryption) key to a remote host.
-func send(rhost string) error {
+// send sends the local public keys and the name of the local host to a remote host.
+// If the remote host cannot be reached directly and there is a relay server, the keys are sent through it.
+func send(rhost string, opts Options) error {
 	// Load keys from disc.
 	boxPubKey, sigPubKey, err := loadKeys()
 	if err != nil {
 		return err
 	}
 	// Send them to the remote host.
-	return sendKeys(rhost, boxPubKey, sigPubKey)
+	return sendKeys(rhost, opts, boxPubKey, sigPubKey, identity.LocalName())
 }
 
 func loadKeys() (key.BoxPublicKey, key.SigPublicKey, error) {
@@ -30,15 +35,12 @@ func loadKeys() (key.BoxPublicKey, key.SigPublicKey, error) {
 	return boxPubKey, sigPubKey, err
 }
 
-func sendKeys(rhost string, boxPubKey key.BoxPublicKey, sigPubKey key.SigPublicKey) error {
-	raddr := net.JoinHostPort(rhost, fmt.Sprintf("%d", port))
-	util.Logf("connecting to %s...", raddr)
-	conn, err := dialWithTimeout(network, raddr, timeout)
+func sendKeys(rhost string, opts Options, boxPubKey key.BoxPublicKey, sigPubKey key.SigPublicKey, name string) error {
+	conn, err := dialHost(rhost, opts)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	util.Logf("connected to %s", raddr)
 
 	if _, err := conn.Write(boxPubKey[:]); err != nil {
 		return err
@@ -48,9 +50,35 @@ func sendKeys(rhost string, boxPubKey key.BoxPublicKey, sigPubKey key.SigPublicK
 	}
 	util.Logf("sent public keys to %s", rhost)
 
+	if _, err := conn.Write([]byte(name)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// dialHost connects to the handshake port of a remote host.
+// Without a relay server, it keeps trying until the remote host is listening.
+// With one, it tries to connect directly once, and then goes through the relay.
+func dialHost(rhost string, opts Options) (net.Conn, error) {
+	raddr := hose_net.HostPort(rhost, opts.port())
+	util.Logf("connecting to %s...", raddr)
+	if opts.Relay == "" {
+		conn, err := dialWithTimeout(network, raddr, timeout)
+		if err == nil {
+			util.Logf("connected to %s", raddr)
+		}
+		return conn, err
+	}
+	conn, err := net.DialTimeout(network, raddr, retryInterval)
+	if err == nil {
+		util.Logf("connected to %s", raddr)
+		return conn, nil
+	}
+	util.Logf("%v", err)
+	return relay.Dial(opts.Relay, opts.Channel)
+}
+
 func dialWithTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/handshake"
+	"git.samanthony.xyz/hose/identity"
+)
+
+const handshakeUsage = "Usage: hose handshake -import <identity> [-name <name>] [-alias <alias,...>] [-replace] [-fingerprint <format>]"
+
+// handshakeCmd saves the identity of a remote host that was printed by "hose whoami -qr".
+func handshakeCmd(args []string) error {
+	flags := flag.NewFlagSet("handshake", flag.ExitOnError)
+	importFlag := flags.String("import", "", "identity printed by hose whoami -qr on the remote host")
+	name := flags.String("name", "", "name to save the remote host under (default: the name in the identity)")
+	aliases := flags.String("alias", "", "comma-separated aliases of the remote host")
+	replace := flags.Bool("replace", false, "accept changed keys of a known host")
+	var format fingerprint.Format
+	flags.Var(&format, "fingerprint", "fingerprint format: hex, words, emoji or art")
+	flags.Parse(args)
+	if *importFlag == "" || flags.NArg() > 0 {
+		return errors.New(handshakeUsage)
+	}
+
+	id, err := identity.Parse(*importFlag)
+	if err != nil {
+		return err
+	}
+	return handshake.Import(id, handshake.Options{
+		Name:        *name,
+		Aliases:     splitList(*aliases),
+		Replace:     *replace,
+		Fingerprint: format,
+	})
+}
@@ -0,0 +1,256 @@
+package hosts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+
+	"git.samanthony.xyz/hose/profile"
+	"git.samanthony.xyz/hose/util"
+)
+
+// fileVersion is the version of the format of the known hosts file that this version of hose writes.
+// Version 1 is the line format of Read and Write. Version 2 is a JSON document.
+const fileVersion = 2
+
+// fileMinorVersion is the minor version of the format of the known hosts file that this version of hose writes.
+// Newer minor versions of the same version only add fields; files of a newer minor version are read,
+// and the fields that this version of hose does not know are kept.
+const fileMinorVersion = 0
+
+// knownHostsFile returns the path of the selected profile's known hosts file.
+func knownHostsFile() string {
+	return profile.Path("known_hosts")
+}
+
+// jsonFile is the JSON representation of the known hosts file.
+type jsonFile struct {
+	Version int    `json:"version"`
+	Minor   int    `json:"minor,omitempty"` // minor version.
+	Hash    bool   `json:"hash,omitempty"`  // names and addresses are hashed.
+	Hosts   []Host `json:"hosts"`
+
+	// extra holds the fields of the file that this version of hose does not know,
+	// so that they are preserved when the file is written.
+	extra map[string]json.RawMessage
+}
+
+// jsonFileFields are the names of the fields of jsonFile.
+// Other fields are preserved in jsonFile.extra.
+var jsonFileFields = jsonFields(reflect.TypeFor[jsonFile]())
+
+func (f jsonFile) MarshalJSON() ([]byte, error) {
+	type fields jsonFile // without the methods.
+	buf, err := json.Marshal(fields(f))
+	if err != nil {
+		return nil, err
+	}
+	return appendFields(buf, f.extra)
+}
+
+// UnmarshalJSON decodes a file encoded by MarshalJSON. Unknown fields are kept.
+func (f *jsonFile) UnmarshalJSON(b []byte) error {
+	type fields jsonFile // without the methods.
+	var file fields
+	if err := json.Unmarshal(b, &file); err != nil {
+		return err
+	}
+	extra, err := unknownFields(b, jsonFileFields)
+	if err != nil {
+		return err
+	}
+	*f = jsonFile(file)
+	f.extra = extra
+	return nil
+}
+
+// Load loads the set of known hosts from disc.
+// A known hosts file in the line format of version 1 is read as well;
+// it is migrated to the current version the next time it is stored, which happens under the lock.
+// The returned list is sorted by name.
+func Load() ([]Host, error) {
+	buf, err := os.ReadFile(knownHostsFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return make([]Host, 0), nil // no known hosts yet.
+	} else if err != nil {
+		return make([]Host, 0), err
+	}
+
+	if len(bytes.TrimSpace(buf)) == 0 {
+		return make([]Host, 0), nil
+	} else if !isJSON(buf) {
+		hosts, err := Read(bytes.NewReader(buf))
+		if err != nil {
+			return hosts, fmt.Errorf("error parsing known hosts file: %s: %v", knownHostsFile(), err)
+		}
+		return hosts, nil
+	}
+	hosts, err := decodeFile(buf)
+	if err != nil {
+		return hosts, fmt.Errorf("error parsing known hosts file: %s: %v", knownHostsFile(), err)
+	}
+	return hosts, nil
+}
+
+// Store stores the set of known hosts to disc. It overwrites the entire file.
+// The file is replaced atomically, so that it is never left partially written,
+// and the previous version is kept as a backup.
+// If the file is hashed, the names and addresses of the hosts are hashed before they are stored.
+// Store does not lock the file; callers that read, modify and store the known hosts must hold the lock.
+func Store(hosts []Host) error {
+	file, err := header()
+	if err != nil {
+		return err
+	}
+	file.Hosts = hosts
+	return store(file)
+}
+
+// store stores a known hosts file, keeping the fields of a file of a newer minor version.
+func store(file jsonFile) error {
+	if file.Hash {
+		for i := range file.Hosts {
+			file.Hosts[i] = file.Hosts[i].hashed()
+		}
+	}
+	slices.SortFunc(file.Hosts, cmpHost)
+	buf, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(knownHostsFile()), dirMode); err != nil {
+		return err
+	}
+	if err := migrate(); err != nil {
+		return err
+	}
+	if err := backup(knownHostsFile()); err != nil {
+		return err
+	}
+	return writeFile(knownHostsFile(), append(buf, '\n'), 0644)
+}
+
+// backup copies a file to a file of the same name with the suffix ".bak", if it exists.
+func backup(name string) error {
+	buf, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return writeFile(name+".bak", buf, 0644)
+}
+
+// writeFile atomically replaces a file with the given data.
+// The data is written to a temporary file in the same directory, synced to disc, and renamed to the file.
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // in case of failure.
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// header returns the known hosts file without its hosts.
+// The version is the current one, unless the file is of a newer minor version.
+func header() (jsonFile, error) {
+	current := jsonFile{Version: fileVersion, Minor: fileMinorVersion}
+	buf, err := os.ReadFile(knownHostsFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return current, nil
+	} else if err != nil || !isJSON(buf) {
+		return current, err
+	}
+	var file jsonFile
+	if err := json.Unmarshal(buf, &file); err != nil {
+		return current, fmt.Errorf("error parsing known hosts file: %s: %v", knownHostsFile(), err)
+	} else if file.Version > current.Version {
+		return current, fmt.Errorf("error parsing known hosts file: %s: version %d is not supported; upgrade hose", knownHostsFile(), file.Version)
+	}
+	if file.Version < current.Version || file.Minor < current.Minor {
+		file.Version, file.Minor = current.Version, current.Minor
+	}
+	file.Hosts = nil
+	return file, nil
+}
+
+// decodeFile decodes a known hosts file of the current version, or of a newer minor version.
+func decodeFile(buf []byte) ([]Host, error) {
+	var file jsonFile
+	if err := json.Unmarshal(buf, &file); err != nil {
+		return make([]Host, 0), err
+	}
+	if file.Version > fileVersion {
+		return make([]Host, 0), fmt.Errorf("version %d is not supported; upgrade hose", file.Version)
+	}
+	hosts := file.Hosts
+	if hosts == nil {
+		hosts = make([]Host, 0)
+	}
+	for _, host := range hosts {
+		for _, name := range host.Names() {
+			if err := ValidateName(name); err != nil {
+				return hosts, err
+			}
+		}
+	}
+	slices.SortFunc(hosts, cmpHost)
+	for i := 1; i < len(hosts); i++ {
+		if hosts[i].Name == hosts[i-1].Name {
+			return hosts, fmt.Errorf("duplicate entry: %s", hosts[i].Name)
+		}
+	}
+	return hosts, checkNames(hosts)
+}
+
+// migrate keeps a copy of a known hosts file in the line format of version 1
+// before store replaces it with the current version. It is called with the lock held.
+func migrate() error {
+	buf, err := os.ReadFile(knownHostsFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if len(bytes.TrimSpace(buf)) == 0 || isJSON(buf) {
+		return nil
+	}
+	backup := knownHostsFile() + ".v1"
+	if err := writeFile(backup, buf, 0644); err != nil {
+		return err
+	}
+	util.Logf("migrated known hosts file %s to version %d; the old file is saved as %s", knownHostsFile(), fileVersion, backup)
+	return nil
+}
+
+// isJSON reports whether a file is a JSON document rather than a list of lines.
+func isJSON(buf []byte) bool {
+	buf = bytes.TrimSpace(buf)
+	return len(buf) > 0 && buf[0] == '{'
+}
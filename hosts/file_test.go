@@ -0,0 +1,227 @@
+package hosts
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"git.samanthony.xyz/hose/profile"
+)
+
+// useTempDir makes the known hosts file of the test live in a temporary directory.
+func useTempDir(t *testing.T) {
+	profile.SetDataDir(t.TempDir())
+	if err := profile.Select(profile.Default); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readHosts reads known hosts in the line format.
+func readHosts(t *testing.T, lines ...string) []Host {
+	hosts, err := Read(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return hosts
+}
+
+// checkHosts fails the test if a list of hosts is not the one in the line format.
+func checkHosts(t *testing.T, got []Host, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d hosts %q; want %d %q", len(got), got, len(want), want)
+	}
+	for i := range got {
+		if got[i].String() != want[i] {
+			t.Errorf("host %d = %q; want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+	}{
+		{"empty", nil},
+		{"one host", []string{"bob " + boxKey1 + " " + sigKey1}},
+		{
+			"every attribute",
+			[]string{
+				"alice " + boxKey3 + " " + sigKey3 + " device=true",
+				"bob,robert " + boxKey1 + " " + sigKey1 + " 10.0.0.2 fe80::1%eth0 added=2024-01-02T03:04:05Z seen=2024-02-03T04:05:06Z" +
+					" trust=introduced introducer=true introduced-by=" + fp1 + " port=7000 comment=a%20b",
+				"carol " + boxKey2 + " " + sigKey2 + " trust=tofu",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTempDir(t)
+			if err := Store(readHosts(t, test.lines...)); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			hosts, err := Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			checkHosts(t, hosts, test.lines...)
+		})
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	useTempDir(t)
+	hosts, err := Load()
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("Load = %q, %v; want no hosts", hosts, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		err  string // expected substring of the error.
+	}{
+		{"malformed JSON", "{\"version\": 2, \"hosts\": [\n", "error parsing known hosts file"},
+		{"newer version", "{\"version\": 99, \"hosts\": []}\n", "version 99 is not supported"},
+		{"newer version with a minor version", "{\"version\": 3, \"minor\": 1, \"hosts\": []}\n", "version 3 is not supported"},
+		{"bad key", "{\"version\": 2, \"hosts\": [{\"name\": \"bob\", \"box_key\": \"xyz\", \"sig_key\": \"" + sigKey1 + "\"}]}\n", "error parsing known hosts file"},
+		{"malformed line", "bob " + boxKey1 + "\n", "line 1: expected at least 3 fields"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTempDir(t)
+			if err := os.WriteFile(knownHostsFile(), []byte(test.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load()
+			if err == nil {
+				t.Fatalf("Load succeeded; want error %q", test.err)
+			} else if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Load error = %q; want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	useTempDir(t)
+	file := "{\"version\": 2, \"hosts\": [{\"name\": \"bob\", \"box_key\": \"" + boxKey1 + "\", \"sig_key\": \"" + sigKey1 + "\", \"color\": \"blue\"}]}\n"
+	if err := os.WriteFile(knownHostsFile(), []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetComment("bob", "hi"); err != nil {
+		t.Fatalf("SetComment: %v", err)
+	}
+	buf, err := os.ReadFile(knownHostsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), `"color": "blue"`) {
+		t.Errorf("unknown field was not kept:\n%s", buf)
+	}
+}
+
+func TestNewerMinorVersion(t *testing.T) {
+	useTempDir(t)
+	file := "{\"version\": 2, \"minor\": 5, \"color\": \"blue\", \"hosts\": [{\"name\": \"bob\", \"box_key\": \"" + boxKey1 + "\", \"sig_key\": \"" + sigKey1 + "\"}]}\n"
+	if err := os.WriteFile(knownHostsFile(), []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checkHosts(t, hosts, "bob "+boxKey1+" "+sigKey1)
+
+	// Storing keeps the minor version and the unknown fields.
+	if err := SetComment("bob", "hi"); err != nil {
+		t.Fatalf("SetComment: %v", err)
+	}
+	buf, err := os.ReadFile(knownHostsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"version": 2`, `"minor": 5`, `"color": "blue"`} {
+		if !strings.Contains(string(buf), field) {
+			t.Errorf("known hosts file does not contain %s:\n%s", field, buf)
+		}
+	}
+	hosts, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checkHosts(t, hosts, "bob "+boxKey1+" "+sigKey1+" comment=hi")
+}
+
+func TestUnknownFileFields(t *testing.T) {
+	useTempDir(t)
+	file := "{\"version\": 2, \"hosts\": [], \"synced\": {\"at\": \"2024-01-02T03:04:05Z\"}}\n"
+	if err := os.WriteFile(knownHostsFile(), []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(mustParse(t, "bob "+boxKey1+" "+sigKey1)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := Hash(); err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	buf, err := os.ReadFile(knownHostsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Minor  *int `json:"minor"`
+		Hash   bool `json:"hash"`
+		Synced struct {
+			At string `json:"at"`
+		} `json:"synced"`
+	}
+	if err := json.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Synced.At != "2024-01-02T03:04:05Z" || !got.Hash || got.Minor != nil {
+		t.Errorf("unknown field was not kept, or the header is wrong:\n%s", buf)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	useTempDir(t)
+	v1 := "bob " + boxKey1 + " " + sigKey1 + " 10.0.0.2\n"
+	if err := os.WriteFile(knownHostsFile(), []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading does not migrate the file.
+	hosts, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checkHosts(t, hosts, strings.TrimSpace(v1))
+	if _, err := os.Stat(knownHostsFile() + ".v1"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load created %s.v1", knownHostsFile())
+	}
+
+	// Storing does, and keeps the old file.
+	if err := SetComment("bob", "hi"); err != nil {
+		t.Fatalf("SetComment: %v", err)
+	}
+	buf, err := os.ReadFile(knownHostsFile())
+	if err != nil {
+		t.Fatal(err)
+	} else if !isJSON(buf) {
+		t.Errorf("known hosts file was not migrated:\n%s", buf)
+	}
+	if buf, err := os.ReadFile(knownHostsFile() + ".v1"); err != nil || string(buf) != v1 {
+		t.Errorf("%s.v1 = %q, %v; want %q", knownHostsFile(), buf, err, v1)
+	}
+	hosts, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checkHosts(t, hosts, strings.TrimSpace(v1)+" comment=hi")
+}
@@ -0,0 +1,179 @@
+package hosts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"git.samanthony.xyz/hose/util"
+)
+
+// hashPrefix starts a hashed name or address: "|1|salt|mac",
+// where mac is the HMAC-SHA256 of the name or address keyed with the salt, and both are base64-encoded.
+const hashPrefix = "|1|"
+
+// saltLen is the length of the salt of a hashed name or address.
+const saltLen = 16
+
+// hash hashes a name or address with a new random salt.
+// Hashed names and addresses are not hashed again.
+func hash(s string) string {
+	if IsHashed(s) {
+		return s
+	}
+	salt := make([]byte, saltLen)
+	rand.Read(salt)
+	return hashPrefix + base64.RawStdEncoding.EncodeToString(salt) + "|" + base64.RawStdEncoding.EncodeToString(mac(salt, s))
+}
+
+func mac(salt []byte, s string) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// IsHashed reports whether a name or address in the known hosts file is hashed.
+func IsHashed(s string) bool {
+	return strings.HasPrefix(s, hashPrefix)
+}
+
+// match reports whether a name or address in the known hosts file, which may be hashed, is s.
+func match(entry, s string) bool {
+	if !IsHashed(entry) {
+		return entry == s
+	}
+	encSalt, encMAC, ok := strings.Cut(strings.TrimPrefix(entry, hashPrefix), "|")
+	if !ok {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(encSalt)
+	if err != nil {
+		return false
+	}
+	sum, err := base64.RawStdEncoding.DecodeString(encMAC)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sum, mac(salt, s))
+}
+
+// matcher returns a function that reports whether a name or address in the known hosts file is s.
+func matcher(s string) func(entry string) bool {
+	return func(entry string) bool { return match(entry, s) }
+}
+
+// sameName reports whether two names or addresses, either of which may be hashed, are the same.
+// Different hashes cannot be compared with each other, since their salts differ; ok is false for them.
+func sameName(a, b string) (same, ok bool) {
+	switch {
+	case a == b:
+		return true, true
+	case IsHashed(a) && IsHashed(b):
+		return false, false
+	case IsHashed(b):
+		return match(b, a), true
+	}
+	return match(a, b), true
+}
+
+// HasName reports whether the host's name, which may be hashed, is name.
+func (h Host) HasName(name string) bool {
+	return match(h.Name, name)
+}
+
+// HasAlias reports whether one of the host's aliases, which may be hashed, is alias.
+func (h Host) HasAlias(alias string) bool {
+	return slices.ContainsFunc(h.Aliases, matcher(alias))
+}
+
+// hashed returns a copy of the host with its name, aliases and addresses hashed.
+func (h Host) hashed() Host {
+	h.Name = hash(h.Name)
+	aliases := make([]string, len(h.Aliases))
+	for i, alias := range h.Aliases {
+		aliases[i] = hash(alias)
+	}
+	h.Aliases = aliases
+
+	addrs := make([]string, 0, len(h.Addrs))
+	for _, addr := range h.Addrs {
+		if IsHashed(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, addr := range h.Addrs {
+		if !IsHashed(addr) && !slices.ContainsFunc(addrs, matcher(addr)) {
+			addrs = append(addrs, hash(addr))
+		}
+	}
+	h.Addrs = addrs
+	return h
+}
+
+// indexName returns the index of the host with the given name in a list sorted by name.
+// If there is none, it returns the index where such a host would be inserted, and false.
+func indexName(hosts []Host, name string) (int, bool) {
+	i, ok := slices.BinarySearchFunc(hosts, name, cmpHostName)
+	if ok {
+		return i, true
+	}
+	if j := slices.IndexFunc(hosts, func(host Host) bool { return IsHashed(host.Name) && host.HasName(name) }); j >= 0 {
+		return j, true
+	}
+	return i, false
+}
+
+// hashing reports whether names and addresses are hashed in the known hosts file.
+func hashing() (bool, error) {
+	file, err := header()
+	return file.Hash, err
+}
+
+// Hash hashes the names and addresses in the known hosts file and in the history file, like HashKnownHosts of OpenSSH.
+// From then on, the names and addresses of hosts that are added are hashed as well.
+// Hosts can still be looked up by name and address, but the file does not reveal them.
+// Hashing cannot be undone.
+func Hash() error {
+	return locked(func() error {
+		hosts, err := Load()
+		if err != nil {
+			return err
+		}
+		file, err := header()
+		if err != nil {
+			return err
+		}
+		file.Hash, file.Hosts = true, hosts
+		if err := store(file); err != nil {
+			return err
+		}
+		// The backups still reveal the names and addresses.
+		if err := backup(knownHostsFile()); err != nil {
+			return err
+		}
+		util.Logf("replaced %s with a hashed copy", knownHostsFile()+".bak")
+		v1 := knownHostsFile() + ".v1"
+		if err := os.Remove(v1); err == nil {
+			util.Logf("deleted %s, which is not hashed", v1)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		events, err := History()
+		if err != nil {
+			return err
+		}
+		var history strings.Builder
+		for _, event := range events {
+			event.Name = hash(event.Name)
+			fmt.Fprintf(&history, "%s\n", event)
+		}
+		return writeFile(historyFile(), []byte(history.String()), 0644)
+	})
+}
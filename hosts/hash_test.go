@@ -0,0 +1,110 @@
+package hosts
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	useTempDir(t)
+	lines := []string{
+		"bob,robert " + boxKey1 + " " + sigKey1 + " 10.0.0.2",
+		"carol " + boxKey2 + " " + sigKey2,
+	}
+	if err := Store(readHosts(t, lines...)); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := Hash(); err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	for _, name := range []string{knownHostsFile(), knownHostsFile() + ".bak"} {
+		buf, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"bob", "robert", "10.0.0.2", "carol"} {
+			if strings.Contains(string(buf), s) {
+				t.Errorf("%s contains %q after hashing", name, s)
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string // signature key of the host that is found; "" if none is.
+	}{
+		{"bob", sigKey1},
+		{"robert", sigKey1},
+		{"10.0.0.2", sigKey1},
+		{"carol", sigKey2},
+		{"dave", ""},
+	}
+	for _, test := range tests {
+		host, err := Lookup(test.name)
+		if test.want == "" {
+			if !errors.Is(err, ErrNoSuchHost) {
+				t.Errorf("Lookup(%q) = %v; want ErrNoSuchHost", test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("Lookup(%q): %v", test.name, err)
+		} else if got := strings.Fields(host.String())[2]; got != test.want {
+			t.Errorf("Lookup(%q) has key %s; want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckNamesHashed(t *testing.T) {
+	bob := mustParse(t, "bob,x "+boxKey1+" "+sigKey1).hashed()
+	tests := []struct {
+		name  string
+		other string
+		ok    bool
+	}{
+		{"plain duplicate of hashed alias", "carol,x " + boxKey2 + " " + sigKey2, false},
+		{"plain duplicate of hashed name", "bob " + boxKey2 + " " + sigKey2, false},
+		{"different names", "carol,y " + boxKey2 + " " + sigKey2, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkNames([]Host{bob, mustParse(t, test.other)})
+			if (err == nil) != test.ok {
+				t.Errorf("checkNames = %v; want ok = %t", err, test.ok)
+			}
+		})
+	}
+}
+
+// mustParse parses a line of the known hosts file. It panics if t is nil and the line is malformed.
+
+func TestMergeHashed(t *testing.T) {
+	useTempDir(t)
+	if err := Store(readHosts(t, "bob,robert "+boxKey1+" "+sigKey1+addedAt)); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := Hash(); err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	writeHistory(t, []Event{{later, HostRemoved, hash("dave"), fp3, ""}})
+
+	remote := readHosts(t,
+		"bob "+boxKey1+" "+sigKey1+" 10.0.0.2"+addedAt, // same keys: merged.
+		"carol,robert "+boxKey2+" "+sigKey2+addedAt,    // alias of a hashed host: not added.
+		"dave "+boxKey3+" "+sigKey3+addedAt,            // removed here: not added.
+	)
+	if err := merge(remote, nil, false); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	hosts, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(hosts) != 1 {
+		t.Fatalf("merged hosts = %q; want bob only", hosts)
+	}
+	if !hosts[0].HasName("bob") || !hosts[0].HasAddrString("10.0.0.2") {
+		t.Errorf("merged host = %q; want bob with address 10.0.0.2", hosts[0])
+	}
+}
@@ -0,0 +1,126 @@
+package hosts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.samanthony.xyz/hose/profile"
+)
+
+// EventKind is the kind of an entry in the history file.
+type EventKind string
+
+const (
+	HostAdded   EventKind = "added"
+	KeysChanged EventKind = "changed"
+	HostRemoved EventKind = "removed"
+)
+
+// Event is an entry in the history file, which records changes to the keys in the known hosts file.
+type Event struct {
+	Time           time.Time `json:"time"`
+	Kind           EventKind `json:"event"`
+	Name           string    `json:"name"`                      // name of the host.
+	OldFingerprint string    `json:"old_fingerprint,omitempty"` // fingerprint of the keys before the event; "" if the host was added.
+	NewFingerprint string    `json:"new_fingerprint,omitempty"` // fingerprint of the keys after the event; "" if the host was removed.
+}
+
+// historyFile returns the path of the selected profile's history file.
+func historyFile() string {
+	return profile.Path("known_hosts.history")
+}
+
+func newEvent(kind EventKind, name, oldFingerprint, newFingerprint string) Event {
+	return Event{now(), kind, name, oldFingerprint, newFingerprint}
+}
+
+// record appends an event to the history file. The zero Event is not recorded.
+func record(event Event) error {
+	if event == (Event{}) {
+		return nil
+	}
+	if hashed, err := hashing(); err != nil {
+		return err
+	} else if hashed {
+		event.Name = hash(event.Name)
+	}
+	if err := os.MkdirAll(filepath.Dir(historyFile()), dirMode); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\n", event); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// History reads the events in the history file, oldest first.
+func History() ([]Event, error) {
+	events := make([]Event, 0)
+
+	f, err := os.Open(historyFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return events, nil // no history yet.
+	} else if err != nil {
+		return events, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		event, err := parseEvent(scanner.Text())
+		if err != nil {
+			return events, fmt.Errorf("error parsing history file: %s:%d: %v", historyFile(), line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// parseEvent parses a line of the history file.
+// A line has the form "time kind name old-fingerprint new-fingerprint",
+// where a missing fingerprint is written as "-".
+func parseEvent(line string) (Event, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		return Event{}, fmt.Errorf("expected 5 fields; got %d", len(fields))
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{t, EventKind(fields[1]), fields[2], unDash(fields[3]), unDash(fields[4])}, nil
+}
+
+// HasName reports whether the event happened to the host with the given name.
+func (e Event) HasName(name string) bool {
+	return match(e.Name, name)
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s %s %s %s",
+		e.Time.Format(time.RFC3339), e.Kind, e.Name, dash(e.OldFingerprint), dash(e.NewFingerprint))
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func unDash(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
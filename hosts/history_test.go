@@ -0,0 +1,42 @@
+package hosts
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		line string
+		want Event
+	}{
+		{"2024-01-02T03:04:05Z added bob - " + fp1, Event{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), HostAdded, "bob", "", fp1}},
+		{"2024-01-02T03:04:05Z changed bob " + fp1 + " " + fp2, Event{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), KeysChanged, "bob", fp1, fp2}},
+		{"2024-01-02T03:04:05Z removed bob " + fp2 + " -", Event{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), HostRemoved, "bob", fp2, ""}},
+	}
+	for _, test := range tests {
+		event, err := parseEvent(test.line)
+		if err != nil {
+			t.Errorf("parseEvent(%q): %v", test.line, err)
+		} else if event != test.want {
+			t.Errorf("parseEvent(%q) = %v; want %v", test.line, event, test.want)
+		} else if event.String() != test.line {
+			t.Errorf("parseEvent(%q).String() = %q", test.line, event)
+		}
+	}
+}
+
+func TestParseEventErrors(t *testing.T) {
+	tests := []struct{ line, err string }{
+		{"", "expected 5 fields; got 0"},
+		{"2024-01-02T03:04:05Z added bob -", "expected 5 fields; got 4"},
+		{"yesterday added bob - " + fp1, "cannot parse"},
+	}
+	for _, test := range tests {
+		_, err := parseEvent(test.line)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parseEvent(%q) error = %v; want %q", test.line, err, test.err)
+		}
+	}
+}
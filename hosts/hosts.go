@@ -3,97 +3,384 @@ package hosts
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/adrg/xdg"
+	"io"
 	"net/netip"
+	"net/url"
 	"os"
-	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
+	"git.samanthony.xyz/hose/fingerprint"
 	"git.samanthony.xyz/hose/key"
+	hose_net "git.samanthony.xyz/hose/net"
 	"git.samanthony.xyz/hose/util"
 )
 
-var knownHostsFile = filepath.Join(xdg.DataHome, "hose", "known_hosts")
+const dirMode os.FileMode = 0755
 
+// ErrNoSuchHost is returned when a host is not in the known hosts file.
+var ErrNoSuchHost = errors.New("no such host")
+
+// Host is an identity in the known hosts file.
+// A host is identified by its keys, not by its address.
 type Host struct {
-	netip.Addr       // address.
-	key.BoxPublicKey // public encryption key.
-	key.SigPublicKey // public signature verification key.
+	Name             string     // unique name of the host.
+	Aliases          []string   // other unique names of the host.
+	Addrs            []string   // addresses and hostnames that the host may be reachable at.
+	Port             uint16     // port that the host receives transfers on; 0 if it is the default.
+	key.BoxPublicKey            // public encryption key.
+	key.SigPublicKey            // public signature verification key.
+	Added            time.Time  // time the host was added to the known hosts file.
+	LastSeen         time.Time  // time of the last transfer with the host; zero if never.
+	Trust            TrustLevel // how the host's keys came to be trusted.
+
+	// Introducer is true if the user trusts the host to introduce other hosts.
+	Introducer bool
+
+	// IntroducedBy is the chain of introducers that vouched for the host's keys, if they are Introduced.
+	// The last introducer introduced the host; each of the others introduced the next one.
+	IntroducedBy []fingerprint.Fingerprint
+
+	// Device is true if the host is another device of the user, which the known hosts are synced with.
+	Device bool
+
+	Comment string // free-form note about the host.
+
+	// extra holds the fields of the host's entry in the known hosts file that this version of hose does not know,
+	// so that they are preserved when the file is written.
+	extra map[string]json.RawMessage
 }
 
-// Add adds or replaces an entry in the known hosts file.
+// Add adds or updates an entry in the known hosts file.
+// The name and aliases of the host must not be used by any other host.
+// If a host with the same name has different keys, a *KeyChangeError is returned
+// and the known hosts file is left unchanged; use Replace to accept the new keys.
+// If the host's added time is zero, it is set to the current time,
+// unless the entry updates an entry with the same keys.
 func Add(host Host) error {
+	return locked(func() error { return add(host, false) })
+}
+
+// Replace is like Add, but it accepts a change of keys.
+// The change is recorded in the history file.
+func Replace(host Host) error {
+	return locked(func() error { return add(host, true) })
+}
+
+func add(host Host, replace bool) error {
+	for _, name := range host.Names() {
+		if err := ValidateName(name); err != nil {
+			return err
+		}
+	}
+
 	hosts, err := Load()
 	if err != nil {
 		return err
 	}
 
-	i, ok := slices.BinarySearchFunc(hosts, host, cmpHost)
+	var event Event
+	i, ok := indexName(hosts, host.Name)
 	if ok {
-		util.Logf("replacing host %q in known hosts file", host.Addr)
+		old := hosts[i]
+		if old.sameKeys(host) {
+			util.Logf("updating host %q in known hosts file", host.Name)
+			if host.Added.IsZero() {
+				host.Added, host.LastSeen = old.Added, old.LastSeen
+			}
+			if old.Trust < host.Trust {
+				// Keys do not lose the trust that they already have.
+				host.Trust, host.IntroducedBy = old.Trust, old.IntroducedBy
+			}
+			host.Introducer = host.Introducer || old.Introducer
+			host.Device = host.Device || old.Device
+			if host.Comment == "" {
+				host.Comment = old.Comment
+			}
+			if host.Port == 0 {
+				host.Port = old.Port
+			}
+			host.extra = old.extra
+		} else if replace {
+			util.Logf("replacing keys of host %q in known hosts file", host.Name)
+			event = newEvent(KeysChanged, host.Name, old.Fingerprint().String(), host.Fingerprint().String())
+		} else {
+			return &KeyChangeError{old, host}
+		}
 		hosts[i] = host
 	} else {
 		hosts = slices.Insert(hosts, i, host)
+		event = newEvent(HostAdded, host.Name, "", host.Fingerprint().String())
+	}
+	if host.Added.IsZero() {
+		hosts[i].Added = now()
+	}
+	if err := checkNames(hosts); err != nil {
+		return err
+	}
+
+	if err := Store(hosts); err != nil {
+		return err
+	}
+	return record(event)
+}
+
+// CheckKeys returns a *KeyChangeError if a known host has the same name as the given host
+// but different keys. The names or addresses that the host was contacted by are checked as well,
+// so that a host cannot avoid the check by being saved under a different name.
+func CheckKeys(host Host, contacted ...string) error {
+	hosts, err := Load()
+	if err != nil {
+		return err
+	}
+	if i, ok := indexName(hosts, host.Name); ok && !hosts[i].sameKeys(host) {
+		return &KeyChangeError{hosts[i], host}
 	}
+	for _, name := range contacted {
+		if name == "" {
+			continue
+		}
+		for _, known := range hosts {
+			if (known.HasName(name) || known.HasAddrString(name)) && !known.sameKeys(host) {
+				return &KeyChangeError{known, host}
+			}
+		}
+	}
+	return nil
+}
 
-	return Store(hosts)
+// Remove removes a host from the known hosts file.
+// The removal is recorded in the history file.
+func Remove(name string) error {
+	var event Event
+	err := update(name, func(hosts []Host, i int) ([]Host, error) {
+		event = newEvent(HostRemoved, name, hosts[i].Fingerprint().String(), "")
+		return slices.Delete(hosts, i, i+1), nil
+	})
+	if err != nil {
+		return err
+	}
+	return record(event)
 }
 
-// Lookup searches for a host in the known hosts file.
+// Rename changes the name of a host in the known hosts file.
+// The new name must not be used by any other host.
+func Rename(oldName, newName string) error {
+	if err := ValidateName(newName); err != nil {
+		return err
+	}
+	return update(oldName, func(hosts []Host, i int) ([]Host, error) {
+		hosts[i].Name = newName
+		hosts[i].Aliases = slices.DeleteFunc(hosts[i].Aliases, func(alias string) bool {
+			return alias == newName
+		})
+		return hosts, checkNames(hosts)
+	})
+}
+
+// SetIntroducer sets whether the user trusts a host to introduce other hosts.
+func SetIntroducer(name string, introducer bool) error {
+	return update(name, func(hosts []Host, i int) ([]Host, error) {
+		hosts[i].Introducer = introducer
+		return hosts, nil
+	})
+}
+
+// SetComment sets the comment of a host.
+func SetComment(name, comment string) error {
+	return update(name, func(hosts []Host, i int) ([]Host, error) {
+		hosts[i].Comment = comment
+		return hosts, nil
+	})
+}
+
+// SetPort sets the port that a host receives transfers on; 0 is the default port.
+func SetPort(name string, port uint16) error {
+	return update(name, func(hosts []Host, i int) ([]Host, error) {
+		hosts[i].Port = port
+		return hosts, nil
+	})
+}
+
+// SetDevice sets whether a host is another device of the user.
+func SetDevice(name string, device bool) error {
+	return update(name, func(hosts []Host, i int) ([]Host, error) {
+		hosts[i].Device = device
+		return hosts, nil
+	})
+}
+
+// Seen records that a transfer with a host happened just now.
+func Seen(name string) error {
+	return update(name, func(hosts []Host, i int) ([]Host, error) {
+		hosts[i].LastSeen = now()
+		return hosts, nil
+	})
+}
+
+// update loads the known hosts, modifies the host with the given name, and stores the result.
+// The modify function receives the list of hosts and the index of the named host.
+func update(name string, modify func(hosts []Host, i int) ([]Host, error)) error {
+	return locked(func() error {
+		hosts, err := Load()
+		if err != nil {
+			return err
+		}
+		i, ok := indexName(hosts, name)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNoSuchHost, name)
+		}
+		hosts, err = modify(hosts, i)
+		if err != nil {
+			return err
+		}
+		return Store(hosts)
+	})
+}
+
+// now returns the current time, truncated to the precision of the known hosts file.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// Lookup searches the known hosts file for a host with the given name, alias or address.
 // If it is not found, a non-nil error is returned.
-func Lookup(hostname netip.Addr) (Host, error) {
+func Lookup(name string) (Host, error) {
 	hosts, err := Load()
 	if err != nil {
 		return Host{}, err
 	}
-	i, ok := slices.BinarySearchFunc(hosts, hostname, cmpHostAddr)
-	if ok {
+	if i, ok := indexName(hosts, name); ok {
 		return hosts[i], nil
 	}
-	return Host{}, fmt.Errorf("no such host: %s", hostname)
+	for _, host := range hosts {
+		if host.HasAlias(name) {
+			return host, nil
+		}
+	}
+	for _, host := range hosts {
+		if host.HasAddrString(name) {
+			return host, nil
+		}
+	}
+	return Host{}, fmt.Errorf("%w: %s", ErrNoSuchHost, name)
 }
 
-// Load loads the set of known hosts from disc.
-// The returned list is sorted.
-func Load() ([]Host, error) {
-	hosts := make([]Host, 0)
+// LookupAddr searches the known hosts file for a host with the given IP address.
+// If it is not found, a non-nil error is returned.
+func LookupAddr(addr netip.Addr) (Host, error) {
+	hosts, err := Load()
+	if err != nil {
+		return Host{}, err
+	}
+	for _, host := range hosts {
+		if host.HasAddr(addr) {
+			return host, nil
+		}
+	}
+	return Host{}, fmt.Errorf("%w: %s", ErrNoSuchHost, addr)
+}
 
-	f, err := os.Open(knownHostsFile)
-	if errors.Is(err, os.ErrNotExist) {
-		return hosts, nil // no known hosts yet.
-	} else if err != nil {
-		return hosts, err
+// LookupSigPublicKey searches the known hosts file for the host that owns a signature verification key.
+// If it is not found, a non-nil error is returned.
+func LookupSigPublicKey(sigPubKey key.SigPublicKey) (Host, error) {
+	hosts, err := Load()
+	if err != nil {
+		return Host{}, err
 	}
-	defer f.Close()
+	for _, host := range hosts {
+		if host.SigPublicKey == sigPubKey {
+			return host, nil
+		}
+	}
+	return Host{}, fmt.Errorf("%w: signature verification key %x", ErrNoSuchHost, sigPubKey)
+}
 
-	scanner := bufio.NewScanner(f)
+// Fingerprint returns a short hash of the host's public keys.
+func (h Host) Fingerprint() fingerprint.Fingerprint {
+	return fingerprint.Of(h.BoxPublicKey, h.SigPublicKey)
+}
+
+// sameKeys reports whether two hosts have the same public keys.
+func (h Host) sameKeys(other Host) bool {
+	return h.BoxPublicKey == other.BoxPublicKey && h.SigPublicKey == other.SigPublicKey
+}
+
+// Names returns the name of the host followed by its aliases.
+func (h Host) Names() []string {
+	return append([]string{h.Name}, h.Aliases...)
+}
+
+// HasAddr reports whether an IP address is one of the host's addresses.
+// Hostnames are not resolved. IPv4-mapped IPv6 addresses match the IPv4 addresses they represent,
+// and link-local addresses without a zone match the same address in any zone.
+func (h Host) HasAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, s := range h.Addrs {
+		if a, err := hose_net.ParseAddr(s); err == nil && hose_net.SameAddr(a, addr) {
+			return true
+		} else if IsHashed(s) && (match(s, addr.String()) || match(s, addr.WithZone("").String())) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAddrString reports whether an address or hostname, as written, is one of the host's addresses.
+func (h Host) HasAddrString(addr string) bool {
+	return slices.ContainsFunc(h.Addrs, matcher(addr))
+}
+
+// Read reads a list of hosts in the line format, which is
+// the format of version 1 of the known hosts file and of "hose hosts export".
+// The returned list is sorted by name.
+func Read(r io.Reader) ([]Host, error) {
+	hosts := make([]Host, 0)
+	scanner := bufio.NewScanner(r)
 	for line := 1; scanner.Scan(); line++ {
 		host, err := parseHost(scanner.Bytes())
 		if err != nil {
-			return hosts, fmt.Errorf("error parsing known hosts file: %s:%d: %v", knownHostsFile, line, err)
+			return hosts, fmt.Errorf("line %d: %v", line, err)
 		}
-		i, ok := slices.BinarySearchFunc(hosts, host, cmpHost)
+		i, ok := slices.BinarySearchFunc(hosts, host.Name, cmpHostName)
 		if ok {
-			return hosts, fmt.Errorf("duplicate entry in known hosts file: %s", host)
+			return hosts, fmt.Errorf("duplicate entry: %s", host.Name)
 		}
 		hosts = slices.Insert(hosts, i, host)
 	}
-	return hosts, scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return hosts, err
+	}
+	return hosts, checkNames(hosts)
 }
 
 // parseHost parses a line of the known hosts file.
+// A line has the form "name[,alias...] boxkey sigkey [addr...] [attr=value...]".
+// The attributes are "added" and "seen", whose values are RFC 3339 timestamps,
+// "trust", whose value is a trust level (hosts without it are verified),
+// "introducer" and "device", which are booleans, "introduced-by", a comma-separated list of fingerprints,
+// "port", a port number, and "comment", which is percent-encoded.
+// Lines written before hosts had names have the form "addr boxkey sigkey";
+// the address doubles as the name of such hosts.
 func parseHost(b []byte) (Host, error) {
 	fields := bytes.Fields(b)
-	if len(fields) != 3 {
-		return Host{}, fmt.Errorf("expected 3 fields; got %d", len(fields))
+	if len(fields) < 3 {
+		return Host{}, fmt.Errorf("expected at least 3 fields; got %d", len(fields))
 	}
 
-	addr, err := netip.ParseAddr(string(fields[0]))
-	if err != nil {
-		return Host{}, err
+	names := strings.Split(string(fields[0]), ",")
+	for _, name := range names {
+		if err := ValidateName(name); err != nil {
+			return Host{}, err
+		}
 	}
+	name, aliases := names[0], names[1:]
 
 	boxPubKey, err := key.DecodeBoxPublicKey(fields[1])
 	if err != nil {
@@ -105,40 +392,159 @@ func parseHost(b []byte) (Host, error) {
 		return Host{}, err
 	}
 
-	return Host{addr, boxPubKey, sigPubKey}, nil
+	host := Host{
+		Name:         name,
+		Aliases:      aliases,
+		Addrs:        make([]string, 0, len(fields)-3),
+		BoxPublicKey: boxPubKey,
+		SigPublicKey: sigPubKey,
+	}
+	for _, field := range fields[3:] {
+		if attr, value, ok := strings.Cut(string(field), "="); ok {
+			if err := host.parseAttr(attr, value); err != nil {
+				return Host{}, err
+			}
+		} else {
+			host.Addrs = append(host.Addrs, string(field))
+		}
+	}
+	if _, err := netip.ParseAddr(name); err == nil && len(aliases) == 0 && len(host.Addrs) == 0 {
+		host.Addrs = append(host.Addrs, name) // old format.
+	}
+
+	return host, nil
 }
 
-// Store stores the set of known hosts to disc. It overwrites the entire file.
-func Store(hosts []Host) error {
-	slices.SortFunc(hosts, cmpHost)
+// parseAttr parses an "attr=value" field of a line of the known hosts file.
+func (h *Host) parseAttr(attr, value string) error {
+	var err error
+	switch attr {
+	case "added":
+		h.Added, err = time.Parse(time.RFC3339, value)
+	case "seen":
+		h.LastSeen, err = time.Parse(time.RFC3339, value)
+	case "trust":
+		h.Trust, err = parseTrustLevel(value)
+	case "introducer":
+		h.Introducer, err = strconv.ParseBool(value)
+	case "introduced-by":
+		h.IntroducedBy, err = parseFingerprints(strings.Split(value, ","))
+	case "device":
+		h.Device, err = strconv.ParseBool(value)
+	case "port":
+		var port uint64
+		port, err = strconv.ParseUint(value, 10, 16)
+		h.Port = uint16(port)
+	case "comment":
+		h.Comment, err = url.PathUnescape(value)
+	default:
+		err = fmt.Errorf("unknown attribute %q", attr)
+	}
+	return err
+}
 
-	f, err := os.Create(knownHostsFile)
-	if err != nil {
-		return err
+// parseFingerprints parses a list of fingerprints.
+func parseFingerprints(list []string) ([]fingerprint.Fingerprint, error) {
+	fps := make([]fingerprint.Fingerprint, len(list))
+	for i, s := range list {
+		fp, err := fingerprint.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		fps[i] = fp
+	}
+	return fps, nil
+}
+
+// ValidateName returns a non-nil error if a string cannot be used as the name or alias of a host.
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty host name")
+	}
+	if strings.ContainsFunc(name, unicode.IsSpace) || strings.Contains(name, ",") {
+		return fmt.Errorf("host name %q contains whitespace or a comma", name)
 	}
-	defer f.Close()
+	return nil
+}
 
+// checkNames returns a non-nil error if a name or alias is used by more than one host.
+// Hashed names are compared through the matcher, except with each other.
+func checkNames(hosts []Host) error {
+	type owned struct{ name, owner string }
+	var seen []owned
 	for _, host := range hosts {
-		fmt.Fprintf(f, "%s\n", host)
+		for _, name := range host.Names() {
+			for _, o := range seen {
+				if same, ok := sameName(o.name, name); same && ok {
+					if IsHashed(name) {
+						name = o.name
+					}
+					return fmt.Errorf("name %q is used by both %q and %q", name, o.owner, host.Name)
+				}
+			}
+			seen = append(seen, owned{name, host.Name})
+		}
 	}
+	return nil
+}
 
+// Write writes a list of hosts in the line format read by Read.
+// It sorts the list by name.
+func Write(w io.Writer, hosts []Host) error {
+	slices.SortFunc(hosts, cmpHost)
+	for _, host := range hosts {
+		if _, err := fmt.Fprintf(w, "%s\n", host); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func cmpHost(a, b Host) int {
-	if x := a.Addr.Compare(b.Addr); x != 0 {
-		return x
-	}
-	if x := a.BoxPublicKey.Compare(b.BoxPublicKey); x != 0 {
-		return x
-	}
-	return a.SigPublicKey.Compare(b.SigPublicKey)
+	return strings.Compare(a.Name, b.Name)
 }
 
-func cmpHostAddr(host Host, addr netip.Addr) int {
-	return host.Addr.Compare(addr)
+func cmpHostName(host Host, name string) int {
+	return strings.Compare(host.Name, name)
 }
 
 func (h Host) String() string {
-	return fmt.Sprintf("%s %x %x", h.Addr, h.BoxPublicKey, h.SigPublicKey)
+	s := fmt.Sprintf("%s %x %x", strings.Join(h.Names(), ","), h.BoxPublicKey, h.SigPublicKey)
+	for _, addr := range h.Addrs {
+		s += " " + addr
+	}
+	if !h.Added.IsZero() {
+		s += " added=" + h.Added.Format(time.RFC3339)
+	}
+	if !h.LastSeen.IsZero() {
+		s += " seen=" + h.LastSeen.Format(time.RFC3339)
+	}
+	if h.Trust != Verified {
+		s += " trust=" + h.Trust.String()
+	}
+	if h.Introducer {
+		s += " introducer=true"
+	}
+	if len(h.IntroducedBy) > 0 {
+		s += " introduced-by=" + strings.Join(formatFingerprints(h.IntroducedBy), ",")
+	}
+	if h.Device {
+		s += " device=true"
+	}
+	if h.Port != 0 {
+		s += " port=" + strconv.Itoa(int(h.Port))
+	}
+	if h.Comment != "" {
+		s += " comment=" + url.PathEscape(h.Comment)
+	}
+	return s
+}
+
+// formatFingerprints formats a list of fingerprints as hex.
+func formatFingerprints(fps []fingerprint.Fingerprint) []string {
+	list := make([]string, len(fps))
+	for i, fp := range fps {
+		list[i] = fp.String()
+	}
+	return list
 }
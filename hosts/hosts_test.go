@@ -0,0 +1,140 @@
+package hosts
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Keys of test hosts, in the hex encoding of the line format.
+var (
+	boxKey1 = strings.Repeat("11", 32)
+	sigKey1 = strings.Repeat("12", 32)
+	boxKey2 = strings.Repeat("21", 32)
+	sigKey2 = strings.Repeat("22", 32)
+	boxKey3 = strings.Repeat("31", 32)
+	sigKey3 = strings.Repeat("32", 32)
+)
+
+// Fingerprints of the test keys.
+var (
+	fp1 = mustParse(nil, "bob "+boxKey1+" "+sigKey1).Fingerprint().String()
+	fp2 = mustParse(nil, "carol "+boxKey2+" "+sigKey2).Fingerprint().String()
+	fp3 = mustParse(nil, "dave "+boxKey3+" "+sigKey3).Fingerprint().String()
+)
+
+func TestReadWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // output of Write; the input if empty.
+	}{
+		{"empty", "", ""},
+		{"minimal", "bob " + boxKey1 + " " + sigKey1 + "\n", ""},
+		{"aliases and addresses", "bob,robert,bobby " + boxKey1 + " " + sigKey1 + " 10.0.0.2 bob.example.com fe80::1%eth0\n", ""},
+		{
+			"attributes",
+			"bob " + boxKey1 + " " + sigKey1 + " 10.0.0.2 added=2024-01-02T03:04:05Z seen=2024-02-03T04:05:06Z trust=introduced" +
+				" introducer=true introduced-by=" + fp1 + " device=true port=7000 comment=hello%20world%2C%20bob\n",
+			"",
+		},
+		{"tofu", "bob " + boxKey1 + " " + sigKey1 + " trust=tofu\n", ""},
+		{"verified is the default", "bob " + boxKey1 + " " + sigKey1 + " trust=verified\n", "bob " + boxKey1 + " " + sigKey1 + "\n"},
+		{
+			"sorted by name",
+			"carol " + boxKey2 + " " + sigKey2 + "\nalice " + boxKey3 + " " + sigKey3 + "\nbob " + boxKey1 + " " + sigKey1 + "\n",
+			"alice " + boxKey3 + " " + sigKey3 + "\nbob " + boxKey1 + " " + sigKey1 + "\ncarol " + boxKey2 + " " + sigKey2 + "\n",
+		},
+		{"old format", "10.0.0.2 " + boxKey1 + " " + sigKey1 + "\n", "10.0.0.2 " + boxKey1 + " " + sigKey1 + " 10.0.0.2\n"},
+		{"extra whitespace", "  bob\t" + boxKey1 + "   " + sigKey1 + "  10.0.0.2 \n", "bob " + boxKey1 + " " + sigKey1 + " 10.0.0.2\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := Read(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := Write(&buf, hosts); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			want := test.want
+			if want == "" {
+				want = test.input
+			}
+			if buf.String() != want {
+				t.Errorf("Write(Read(%q)) = %q; want %q", test.input, buf.String(), want)
+			}
+
+			// Writing is the inverse of reading.
+			again, err := Read(&buf)
+			if err != nil {
+				t.Fatalf("Read(Write(...)): %v", err)
+			}
+			if len(again) != len(hosts) {
+				t.Fatalf("Read(Write(...)) has %d hosts; want %d", len(again), len(hosts))
+			}
+			for i := range hosts {
+				if again[i].String() != hosts[i].String() {
+					t.Errorf("Read(Write(...))[%d] = %q; want %q", i, again[i], hosts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseHost(t *testing.T) {
+	h := mustParse(t, "bob,robert "+boxKey1+" "+sigKey1+" 10.0.0.2 bob.example.com port=7000 trust=tofu comment=a%2Fb")
+	if h.Name != "bob" || len(h.Aliases) != 1 || h.Aliases[0] != "robert" {
+		t.Errorf("names = %q, %q; want bob, [robert]", h.Name, h.Aliases)
+	}
+	if len(h.Addrs) != 2 || h.Addrs[0] != "10.0.0.2" || h.Addrs[1] != "bob.example.com" {
+		t.Errorf("addresses = %q; want [10.0.0.2 bob.example.com]", h.Addrs)
+	}
+	if h.Port != 7000 || h.Trust != TOFU || h.Comment != "a/b" {
+		t.Errorf("port, trust, comment = %d, %s, %q; want 7000, tofu, \"a/b\"", h.Port, h.Trust, h.Comment)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string // expected substring of the error.
+	}{
+		{"too few fields", "bob " + boxKey1 + "\n", "line 1: expected at least 3 fields"},
+		{"bad box key", "bob xyz " + sigKey1 + "\n", "line 1:"},
+		{"short sig key", "bob " + boxKey1 + " 1234\n", "line 1: malformed signature verification key"},
+		{"empty alias", "bob, " + boxKey1 + " " + sigKey1 + "\n", "line 1: empty host name"},
+		{"unknown attribute", "bob " + boxKey1 + " " + sigKey1 + " color=blue\n", "line 1: unknown attribute \"color\""},
+		{"bad time", "bob " + boxKey1 + " " + sigKey1 + " added=yesterday\n", "line 1:"},
+		{"bad trust", "bob " + boxKey1 + " " + sigKey1 + " trust=maybe\n", "line 1: unknown trust level \"maybe\""},
+		{"bad port", "bob " + boxKey1 + " " + sigKey1 + " port=65536\n", "line 1:"},
+		{"bad fingerprint", "bob " + boxKey1 + " " + sigKey1 + " introduced-by=abc\n", "line 1: malformed fingerprint"},
+		{"bad line", "bob " + boxKey1 + " " + sigKey1 + "\ncarol\n", "line 2:"},
+		{"duplicate name", "bob " + boxKey1 + " " + sigKey1 + "\nbob " + boxKey2 + " " + sigKey2 + "\n", "duplicate entry: bob"},
+		{"alias used as name", "bob " + boxKey1 + " " + sigKey1 + "\ncarol,bob " + boxKey2 + " " + sigKey2 + "\n", "name \"bob\" is used by both"},
+		{"duplicate alias", "bob,x " + boxKey1 + " " + sigKey1 + "\ncarol,x " + boxKey2 + " " + sigKey2 + "\n", "name \"x\" is used by both"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(test.input))
+			if err == nil {
+				t.Fatalf("Read succeeded; want error %q", test.err)
+			} else if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Read error = %q; want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, line string) Host {
+	host, err := parseHost([]byte(line))
+	if err != nil {
+		if t == nil {
+			panic(err)
+		}
+		t.Fatalf("parseHost(%q): %v", line, err)
+	}
+	return host
+}
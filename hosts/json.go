@@ -0,0 +1,186 @@
+package hosts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"git.samanthony.xyz/hose/key"
+)
+
+// jsonHost is the JSON representation of a Host.
+type jsonHost struct {
+	Name         string   `json:"name"`
+	Aliases      []string `json:"aliases"`
+	Addrs        []string `json:"addresses"`
+	Port         uint16   `json:"port,omitempty"`
+	BoxKey       string   `json:"box_key"`
+	SigKey       string   `json:"sig_key"`
+	Fingerprint  string   `json:"fingerprint"`
+	Added        string   `json:"added,omitempty"`
+	LastSeen     string   `json:"last_seen,omitempty"`
+	Trust        string   `json:"trust"`
+	Introducer   bool     `json:"introducer"`
+	IntroducedBy []string `json:"introduced_by,omitempty"`
+	Device       bool     `json:"device"`
+	Comment      string   `json:"comment,omitempty"`
+}
+
+// jsonHostFields are the names of the fields of jsonHost.
+// Other fields of a host are preserved in Host.extra.
+var jsonHostFields = jsonFields(reflect.TypeFor[jsonHost]())
+
+// jsonFields returns the names of the JSON fields of a struct type.
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+func (h Host) MarshalJSON() ([]byte, error) {
+	buf, err := json.Marshal(jsonHost{
+		Name:         h.Name,
+		Aliases:      nonNil(h.Aliases),
+		Addrs:        nonNil(h.Addrs),
+		Port:         h.Port,
+		BoxKey:       hex.EncodeToString(h.BoxPublicKey[:]),
+		SigKey:       hex.EncodeToString(h.SigPublicKey[:]),
+		Fingerprint:  h.Fingerprint().String(),
+		Added:        formatTime(h.Added),
+		LastSeen:     formatTime(h.LastSeen),
+		Trust:        h.Trust.String(),
+		Introducer:   h.Introducer,
+		IntroducedBy: formatFingerprints(h.IntroducedBy),
+		Device:       h.Device,
+		Comment:      h.Comment,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appendFields(buf, h.extra)
+}
+
+// appendFields appends fields to a JSON object.
+func appendFields(obj []byte, fields map[string]json.RawMessage) ([]byte, error) {
+	if len(fields) == 0 {
+		return obj, nil
+	}
+	obj = bytes.TrimSuffix(obj, []byte("}"))
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		field, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		if obj[len(obj)-1] != '{' {
+			obj = append(obj, ',')
+		}
+		obj = append(append(append(obj, field...), ':'), fields[name]...)
+	}
+	return append(obj, '}'), nil
+}
+
+// unknownFields returns the fields of a JSON object other than the known ones, or nil if there are none.
+func unknownFields(obj []byte, known []string) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(obj, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range known {
+		delete(fields, name)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// UnmarshalJSON decodes a host encoded by MarshalJSON.
+// The fingerprint is ignored; it is derived from the keys.
+// Unknown fields are kept, and encoded again by MarshalJSON.
+func (h *Host) UnmarshalJSON(b []byte) error {
+	var j jsonHost
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	boxPubKey, err := key.DecodeBoxPublicKey([]byte(j.BoxKey))
+	if err != nil {
+		return err
+	}
+	sigPubKey, err := key.DecodeSigPublicKey([]byte(j.SigKey))
+	if err != nil {
+		return err
+	}
+	added, err := parseTime(j.Added)
+	if err != nil {
+		return err
+	}
+	lastSeen, err := parseTime(j.LastSeen)
+	if err != nil {
+		return err
+	}
+	trust := Verified
+	if j.Trust != "" {
+		trust, err = parseTrustLevel(j.Trust)
+		if err != nil {
+			return err
+		}
+	}
+	introducedBy, err := parseFingerprints(j.IntroducedBy)
+	if err != nil {
+		return err
+	}
+	extra, err := unknownFields(b, jsonHostFields)
+	if err != nil {
+		return err
+	}
+	*h = Host{
+		Name:         j.Name,
+		Aliases:      j.Aliases,
+		Addrs:        j.Addrs,
+		Port:         j.Port,
+		BoxPublicKey: boxPubKey,
+		SigPublicKey: sigPubKey,
+		Added:        added,
+		LastSeen:     lastSeen,
+		Trust:        trust,
+		Introducer:   j.Introducer,
+		IntroducedBy: introducedBy,
+		Device:       j.Device,
+		Comment:      j.Comment,
+		extra:        extra,
+	}
+	return nil
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// formatTime formats a timestamp as RFC 3339. The zero time is formatted as "".
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parseTime parses a timestamp formatted by formatTime.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,32 @@
+package hosts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyChangeError is returned when the keys of a host differ from the keys in the known hosts file.
+// This can mean that someone is impersonating the host, or that the host generated new keys.
+type KeyChangeError struct {
+	Old Host // the host in the known hosts file.
+	New Host // the host with the new keys.
+}
+
+func (e *KeyChangeError) Error() string {
+	return fmt.Sprintf("keys of host %q have changed", e.Old.Name)
+}
+
+// Warning returns a prominent warning about the key change that shows the old and new fingerprints.
+func (e *KeyChangeError) Warning() string {
+	var b strings.Builder
+	banner := strings.Repeat("@", 59)
+	fmt.Fprintf(&b, "%s\n", banner)
+	fmt.Fprintf(&b, "@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n")
+	fmt.Fprintf(&b, "%s\n", banner)
+	fmt.Fprintf(&b, "IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!\n")
+	fmt.Fprintf(&b, "Someone could be impersonating host %q (man-in-the-middle attack).\n", e.Old.Name)
+	fmt.Fprintf(&b, "It is also possible that the host has generated new keys.\n")
+	fmt.Fprintf(&b, "Known fingerprint: %s\n", e.Old.Fingerprint())
+	fmt.Fprintf(&b, "New fingerprint:   %s", e.New.Fingerprint())
+	return b.String()
+}
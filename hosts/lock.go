@@ -0,0 +1,33 @@
+package hosts
+
+import (
+	"os"
+	"path/filepath"
+
+	"git.samanthony.xyz/hose/profile"
+)
+
+// lockFile returns the path of the file that is locked while the known hosts file is being updated.
+func lockFile() string {
+	return profile.Path("known_hosts.lock")
+}
+
+// locked calls f while holding an exclusive lock on the known hosts file,
+// so that hose processes that update the file at the same time do not lose each other's changes.
+// Loading the file does not require the lock, because the file is replaced atomically.
+func locked(f func() error) error {
+	if err := os.MkdirAll(filepath.Dir(lockFile()), dirMode); err != nil {
+		return err
+	}
+	lf, err := os.OpenFile(lockFile(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	if err := lock(lf); err != nil {
+		return err
+	}
+	defer unlock(lf)
+	return f()
+}
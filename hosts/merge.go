@@ -0,0 +1,130 @@
+package hosts
+
+import (
+	"slices"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/util"
+)
+
+// Merge merges the known hosts and history of another device of the user into the known hosts file.
+//
+// Hosts that are only known to the other device are added, unless they were removed from this device.
+// Hosts that are only known to this device are removed if they were removed from the other device.
+// If both devices know a host by the same name with different keys, the other device's keys are accepted
+// if the keys of this device were replaced on the other device later, or if replace is true;
+// otherwise the conflict is reported and the host is left as it is.
+// Names given to hosts on this device are kept, and details like addresses are combined.
+func Merge(remote []Host, remoteHistory []Event, replace bool) error {
+	return locked(func() error { return merge(remote, remoteHistory, replace) })
+}
+
+func merge(remote []Host, remoteHistory []Event, replace bool) error {
+	local, err := Load()
+	if err != nil {
+		return err
+	}
+	localHistory, err := History()
+	if err != nil {
+		return err
+	}
+
+	merged := slices.Clone(local)
+	remoteFps := make(map[fingerprint.Fingerprint]bool)
+	var events []Event
+	for _, r := range remote {
+		remoteFps[r.Fingerprint()] = true
+
+		if i := slices.IndexFunc(merged, r.sameKeys); i >= 0 {
+			merged[i] = mergeHost(merged[i], r)
+			continue
+		}
+
+		i, ok := indexName(merged, r.Name)
+		if ok {
+			// Same name, different keys.
+			old := merged[i]
+			if !replace && !discarded(remoteHistory, old.Name, old.Fingerprint(), old.Added) {
+				util.Logf("%s", (&KeyChangeError{old, r}).Warning())
+				util.Logf("keeping the known keys of host %q; use -replace to accept the keys of the other device", old.Name)
+				remoteFps[old.Fingerprint()] = true // do not remove it below.
+				continue
+			}
+			util.Logf("replacing keys of host %q", r.Name)
+			r.Aliases = old.Aliases
+			r.Added = now()
+			merged[i] = r
+			events = append(events, newEvent(KeysChanged, r.Name, old.Fingerprint().String(), r.Fingerprint().String()))
+			continue
+		}
+
+		if discarded(localHistory, r.Name, r.Fingerprint(), r.Added) {
+			continue // removed from this device.
+		}
+		candidate := slices.Insert(slices.Clone(merged), i, r)
+		if err := checkNames(candidate); err != nil {
+			util.Logf("not adding host %q: %v", r.Name, err)
+			continue
+		}
+		util.Logf("adding host %q", r.Name)
+		merged = candidate
+		events = append(events, newEvent(HostAdded, r.Name, "", r.Fingerprint().String()))
+	}
+
+	merged = slices.DeleteFunc(merged, func(host Host) bool {
+		fp := host.Fingerprint()
+		if remoteFps[fp] || !discarded(remoteHistory, host.Name, fp, host.Added) {
+			return false
+		}
+		util.Logf("removing host %q", host.Name)
+		events = append(events, newEvent(HostRemoved, host.Name, fp.String(), ""))
+		return true
+	})
+
+	if err := Store(merged); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := record(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeHost combines the details of two entries of a host with the same keys.
+// The name and aliases of the local entry are kept.
+func mergeHost(local, remote Host) Host {
+	for _, addr := range remote.Addrs {
+		if !slices.Contains(local.Addrs, addr) {
+			local.Addrs = append(local.Addrs, addr)
+		}
+	}
+	if local.Added.IsZero() || (!remote.Added.IsZero() && remote.Added.Before(local.Added)) {
+		local.Added = remote.Added
+	}
+	if remote.LastSeen.After(local.LastSeen) {
+		local.LastSeen = remote.LastSeen
+	}
+	if remote.Trust < local.Trust {
+		local.Trust, local.IntroducedBy = remote.Trust, remote.IntroducedBy
+	}
+	local.Introducer = local.Introducer || remote.Introducer
+	local.Device = local.Device || remote.Device
+	if local.Port == 0 {
+		local.Port = remote.Port
+	}
+	return local
+}
+
+// discarded reports whether a history shows that the keys with the given fingerprint were removed or replaced
+// from the host with the given name after a point in time.
+// Changes made in the same second are not considered to be after it, so that the keys are kept.
+// Names that are hashed with different salts cannot be compared, so the fingerprint decides for them.
+func discarded(history []Event, name string, fp fingerprint.Fingerprint, after time.Time) bool {
+	return slices.ContainsFunc(history, func(event Event) bool {
+		same, ok := sameName(event.Name, name)
+		return (same || !ok) && event.OldFingerprint == fp.String() && event.Time.After(after)
+	})
+}
@@ -0,0 +1,187 @@
+package hosts
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	added  = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) // when the test hosts were added.
+	before = added.Add(-24 * time.Hour)                  // before they were added.
+	later  = added.Add(24 * time.Hour)                   // when something happened to them.
+
+	addedAt = " added=" + added.Format(time.RFC3339)  // added attribute of the test hosts.
+	earlier = " added=" + before.Format(time.RFC3339) // added attribute of hosts that were added before them.
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name          string
+		local         []string // local known hosts in the line format.
+		localHistory  []Event
+		remote        []string // the other device's known hosts in the line format.
+		remoteHistory []Event
+		replace       bool
+		want          []string // names and keys of the merged known hosts.
+	}{
+		{
+			name:   "new host",
+			local:  []string{"bob " + boxKey1 + " " + sigKey1 + addedAt},
+			remote: []string{"carol " + boxKey2 + " " + sigKey2 + addedAt},
+			want:   []string{"bob " + boxKey1 + " " + sigKey1, "carol " + boxKey2 + " " + sigKey2},
+		},
+		{
+			name:   "same keys under another name",
+			local:  []string{"bob " + boxKey1 + " " + sigKey1 + addedAt},
+			remote: []string{"robert " + boxKey1 + " " + sigKey1 + addedAt},
+			want:   []string{"bob " + boxKey1 + " " + sigKey1},
+		},
+		{
+			name:   "conflicting keys are kept",
+			local:  []string{"bob " + boxKey1 + " " + sigKey1 + addedAt},
+			remote: []string{"bob " + boxKey2 + " " + sigKey2 + addedAt},
+			want:   []string{"bob " + boxKey1 + " " + sigKey1},
+		},
+		{
+			name:    "conflicting keys are replaced",
+			local:   []string{"bob,robert " + boxKey1 + " " + sigKey1 + addedAt},
+			remote:  []string{"bob " + boxKey2 + " " + sigKey2 + addedAt},
+			replace: true,
+			want:    []string{"bob,robert " + boxKey2 + " " + sigKey2},
+		},
+		{
+			name:          "keys replaced on the other device",
+			local:         []string{"bob " + boxKey1 + " " + sigKey1 + addedAt},
+			remote:        []string{"bob " + boxKey2 + " " + sigKey2 + addedAt},
+			remoteHistory: []Event{{later, KeysChanged, "bob", fp1, fp2}},
+			want:          []string{"bob " + boxKey2 + " " + sigKey2},
+		},
+		{
+			name:          "keys replaced on the other device before they were added here",
+			local:         []string{"bob " + boxKey1 + " " + sigKey1 + addedAt},
+			remote:        []string{"bob " + boxKey2 + " " + sigKey2 + addedAt},
+			remoteHistory: []Event{{before, KeysChanged, "bob", fp1, fp2}},
+			want:          []string{"bob " + boxKey1 + " " + sigKey1},
+		},
+		{
+			name:         "host removed here",
+			remote:       []string{"carol " + boxKey2 + " " + sigKey2 + addedAt},
+			localHistory: []Event{{later, HostRemoved, "carol", fp2, ""}},
+			want:         nil,
+		},
+		{
+			name:         "host removed here before it was added there",
+			remote:       []string{"carol " + boxKey2 + " " + sigKey2 + addedAt},
+			localHistory: []Event{{before, HostRemoved, "carol", fp2, ""}},
+			want:         []string{"carol " + boxKey2 + " " + sigKey2},
+		},
+		{
+			name:          "host removed there",
+			local:         []string{"bob " + boxKey1 + " " + sigKey1 + addedAt, "carol " + boxKey2 + " " + sigKey2 + addedAt},
+			remote:        []string{"bob " + boxKey1 + " " + sigKey1 + addedAt},
+			remoteHistory: []Event{{later, HostRemoved, "carol", fp2, ""}},
+			want:          []string{"bob " + boxKey1 + " " + sigKey1},
+		},
+		{
+			name:          "host removed there before it was added here",
+			local:         []string{"carol " + boxKey2 + " " + sigKey2 + addedAt},
+			remoteHistory: []Event{{before, HostRemoved, "carol", fp2, ""}},
+			want:          []string{"carol " + boxKey2 + " " + sigKey2},
+		},
+		{
+			name:          "host removed there with other keys",
+			local:         []string{"carol " + boxKey2 + " " + sigKey2 + earlier},
+			remoteHistory: []Event{{later, HostRemoved, "carol", fp3, ""}},
+			want:          []string{"carol " + boxKey2 + " " + sigKey2},
+		},
+		{
+			name:   "name conflicts with an alias",
+			local:  []string{"bob,carol " + boxKey1 + " " + sigKey1 + addedAt},
+			remote: []string{"carol " + boxKey2 + " " + sigKey2 + addedAt},
+			want:   []string{"bob,carol " + boxKey1 + " " + sigKey1},
+		},
+		{
+			name:   "alias conflicts with a name",
+			local:  []string{"bob " + boxKey1 + " " + sigKey1 + addedAt},
+			remote: []string{"carol,bob " + boxKey2 + " " + sigKey2 + addedAt},
+			want:   []string{"bob " + boxKey1 + " " + sigKey1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTempDir(t)
+			if err := Store(readHosts(t, test.local...)); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			writeHistory(t, test.localHistory)
+
+			if err := merge(readHosts(t, test.remote...), test.remoteHistory, test.replace); err != nil {
+				t.Fatalf("merge: %v", err)
+			}
+			hosts, err := Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			got := make([]string, len(hosts))
+			for i, host := range hosts {
+				got[i] = strings.Join(strings.Fields(host.String())[:3], " ")
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("merged hosts = %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	useTempDir(t)
+	if err := Store(readHosts(t, "bob "+boxKey1+" "+sigKey1+addedAt)); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	remote := readHosts(t, "bob "+boxKey2+" "+sigKey2+addedAt, "carol "+boxKey3+" "+sigKey3+addedAt)
+	if err := merge(remote, nil, true); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	history, err := History()
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	want := []Event{
+		{Kind: KeysChanged, Name: "bob", OldFingerprint: fp1, NewFingerprint: fp2},
+		{Kind: HostAdded, Name: "carol", NewFingerprint: fp3},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history = %v; want %v", history, want)
+	}
+	for i := range want {
+		want[i].Time = history[i].Time
+		if history[i] != want[i] {
+			t.Errorf("event %d = %v; want %v", i, history[i], want[i])
+		}
+	}
+}
+
+func TestMergeHost(t *testing.T) {
+	local := mustParse(t, "bob,robert "+boxKey1+" "+sigKey1+" 10.0.0.2 seen=2024-03-01T00:00:00Z"+addedAt)
+	remote := mustParse(t, "robert "+boxKey1+" "+sigKey1+" 10.0.0.2 bob.example.com seen=2024-04-01T00:00:00Z"+
+		" trust=tofu introducer=true device=true port=7000"+earlier)
+	got := mergeHost(local, remote).String()
+	want := "bob,robert " + boxKey1 + " " + sigKey1 + " 10.0.0.2 bob.example.com" + earlier +
+		" seen=2024-04-01T00:00:00Z introducer=true device=true port=7000"
+	if got != want {
+		t.Errorf("mergeHost = %q; want %q", got, want)
+	}
+}
+
+// writeHistory writes the history file.
+func writeHistory(t *testing.T, events []Event) {
+	var lines strings.Builder
+	for _, event := range events {
+		lines.WriteString(event.String() + "\n")
+	}
+	if err := os.WriteFile(historyFile(), []byte(lines.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
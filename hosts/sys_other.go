@@ -0,0 +1,20 @@
+//go:build !unix
+
+package hosts
+
+import "os"
+
+// lock does nothing on systems without flock(2), where concurrent updates of the known hosts file are not serialized.
+func lock(f *os.File) error {
+	return nil
+}
+
+// unlock does nothing on systems without flock(2).
+func unlock(f *os.File) error {
+	return nil
+}
+
+// syncDir does nothing on systems where directories cannot be synced.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package hosts
+
+import "fmt"
+
+// TrustLevel is how the keys of a host came to be trusted.
+type TrustLevel int
+
+const (
+	// Verified keys were verified by the user, e.g. by comparing fingerprints during a handshake.
+	Verified TrustLevel = iota
+
+	// Introduced keys were vouched for by an introducer: a host that the user trusts to introduce other hosts.
+	Introduced
+
+	// TOFU keys were accepted without verification the first time the host was contacted (trust on first use).
+	TOFU
+)
+
+var trustLevelNames = []string{"verified", "introduced", "tofu"}
+
+func (t TrustLevel) String() string {
+	if int(t) < len(trustLevelNames) {
+		return trustLevelNames[t]
+	}
+	return fmt.Sprintf("TrustLevel(%d)", int(t))
+}
+
+// parseTrustLevel parses the name of a trust level.
+func parseTrustLevel(name string) (TrustLevel, error) {
+	for i, s := range trustLevelNames {
+		if s == name {
+			return TrustLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown trust level %q", name)
+}
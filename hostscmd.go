@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/util"
+)
+
+const hostsUsage = "Usage: hose hosts <list [-json] [-fingerprint <format>] | show [-json] [-fingerprint <format>] <name> | remove <name> | rename <name> <new name> | import [-replace] [-keep-trust] [file] | export [-json] [name...] | history [-json] [name] | introducer [-remove] <name> | device [-remove] <name> | comment <name> [comment...] | port <name> [port] | hash>"
+
+// hostsCommands are the subcommands of "hose hosts".
+var hostsCommands = map[string]func(args []string) error{
+	"list":       hostsList,
+	"show":       hostsShow,
+	"remove":     hostsRemove,
+	"rename":     hostsRename,
+	"import":     hostsImport,
+	"export":     hostsExport,
+	"history":    hostsHistory,
+	"introducer": hostsIntroducer,
+	"device":     hostsDevice,
+	"comment":    hostsComment,
+	"port":       hostsPort,
+	"hash":       hostsHash,
+}
+
+// hostsCmd inspects and edits the known hosts file.
+func hostsCmd(args []string) error {
+	if len(args) < 1 {
+		return errors.New(hostsUsage)
+	}
+	cmd, ok := hostsCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], hostsUsage)
+	}
+	return cmd(args[1:])
+}
+
+// hostsList prints a summary of every known host.
+func hostsList(args []string) error {
+	flags := flag.NewFlagSet("hosts list", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "print JSON")
+	var format fingerprint.Format
+	flags.Var(&format, "fingerprint", "fingerprint format: hex, words or emoji")
+	flags.Parse(args)
+	if format.Multiline() {
+		return fmt.Errorf("fingerprint format %s does not fit in a list; use hose hosts show", format)
+	}
+
+	knownHosts, err := hosts.Load()
+	if err != nil {
+		return err
+	}
+	if *jsonFlag {
+		return printJSON(knownHosts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tALIASES\tADDRESSES\tFINGERPRINT\tTRUST\tADDED\tLAST SEEN")
+	for _, host := range knownHosts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			host.Name, list(host.Aliases), list(host.Addrs), host.Fingerprint().Format(format), host.Trust,
+			formatTime(host.Added), formatTime(host.LastSeen))
+	}
+	return w.Flush()
+}
+
+// hostsShow prints the details of a known host.
+func hostsShow(args []string) error {
+	flags := flag.NewFlagSet("hosts show", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "print JSON")
+	var format fingerprint.Format
+	flags.Var(&format, "fingerprint", "fingerprint format: hex, words, emoji or art")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New(hostsUsage)
+	}
+
+	host, err := hosts.Lookup(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *jsonFlag {
+		return printJSON(host)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "name:\t%s\n", host.Name)
+	fmt.Fprintf(w, "aliases:\t%s\n", list(host.Aliases))
+	fmt.Fprintf(w, "addresses:\t%s\n", list(host.Addrs))
+	fmt.Fprintf(w, "port:\t%d\n", remotePort(host, 0))
+	fmt.Fprintf(w, "encryption key:\t%x\n", host.BoxPublicKey)
+	fmt.Fprintf(w, "signature key:\t%x\n", host.SigPublicKey)
+	fmt.Fprintf(w, "trust:\t%s\n", host.Trust)
+	if len(host.IntroducedBy) > 0 {
+		chain, err := introducerNames(host.IntroducedBy)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "introduced by:\t%s\n", strings.Join(chain, " -> "))
+	}
+	fmt.Fprintf(w, "introducer:\t%t\n", host.Introducer)
+	fmt.Fprintf(w, "device:\t%t\n", host.Device)
+	fmt.Fprintf(w, "comment:\t%s\n", orDash(host.Comment))
+	fmt.Fprintf(w, "added:\t%s\n", formatTime(host.Added))
+	fmt.Fprintf(w, "last seen:\t%s\n", formatTime(host.LastSeen))
+	printFingerprint(w, host.Fingerprint(), format)
+	return w.Flush()
+}
+
+// hostsRemove removes a host from the known hosts file.
+func hostsRemove(args []string) error {
+	if len(args) != 1 {
+		return errors.New(hostsUsage)
+	}
+	return hosts.Remove(args[0])
+}
+
+// hostsRename changes the name of a known host.
+func hostsRename(args []string) error {
+	if len(args) != 2 {
+		return errors.New(hostsUsage)
+	}
+	return hosts.Rename(args[0], args[1])
+}
+
+// hostsIntroducer sets whether a known host is trusted to introduce other hosts.
+func hostsIntroducer(args []string) error {
+	flags := flag.NewFlagSet("hosts introducer", flag.ExitOnError)
+	remove := flags.Bool("remove", false, "stop trusting the host to introduce other hosts")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New(hostsUsage)
+	}
+	return hosts.SetIntroducer(flags.Arg(0), !*remove)
+}
+
+// hostsDevice sets whether a known host is another device of the user, which the known hosts are synced with.
+func hostsDevice(args []string) error {
+	flags := flag.NewFlagSet("hosts device", flag.ExitOnError)
+	remove := flags.Bool("remove", false, "stop syncing with the host")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New(hostsUsage)
+	}
+	return hosts.SetDevice(flags.Arg(0), !*remove)
+}
+
+// hostsComment sets the comment of a known host. Without a comment, the comment is removed.
+func hostsComment(args []string) error {
+	if len(args) < 1 {
+		return errors.New(hostsUsage)
+	}
+	return hosts.SetComment(args[0], strings.Join(args[1:], " "))
+}
+
+// hostsPort sets the port that a known host receives transfers on. Without a port, the default port is used.
+func hostsPort(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New(hostsUsage)
+	}
+	var port uint64
+	if len(args) == 2 {
+		var err error
+		port, err = strconv.ParseUint(args[1], 10, 16)
+		if err != nil || port == 0 {
+			return fmt.Errorf("invalid port %q", args[1])
+		}
+	}
+	return hosts.SetPort(args[0], uint16(port))
+}
+
+// hostsHash hashes the names and addresses in the known hosts file.
+func hostsHash(args []string) error {
+	if len(args) > 0 {
+		return errors.New(hostsUsage)
+	}
+	return hosts.Hash()
+}
+
+// introducerNames returns the names of the known hosts with the given fingerprints.
+// Fingerprints of unknown hosts are returned as they are.
+func introducerNames(fps []fingerprint.Fingerprint) ([]string, error) {
+	knownHosts, err := hosts.Load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(fps))
+	for i, fp := range fps {
+		names[i] = fp.String()
+		j := slices.IndexFunc(knownHosts, func(host hosts.Host) bool { return host.Fingerprint() == fp })
+		if j >= 0 {
+			names[i] = knownHosts[j].Name
+		}
+	}
+	return names, nil
+}
+
+// hostsImport adds hosts to the known hosts file.
+// They are read from a file, or from stdin if no file is given,
+// in either the known hosts format or the JSON format of "hose hosts export -json".
+// Hosts whose keys have changed are skipped unless the -replace flag is given.
+// Imported hosts are trusted on first use, and are neither introducers nor devices,
+// unless the -keep-trust flag is given; known hosts keep the trust that they already have.
+func hostsImport(args []string) error {
+	flags := flag.NewFlagSet("hosts import", flag.ExitOnError)
+	replace := flags.Bool("replace", false, "accept changed keys of known hosts")
+	keepTrust := flags.Bool("keep-trust", false, "keep the trust levels, introducers and devices of the imported hosts")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	default:
+		return errors.New(hostsUsage)
+	}
+
+	imported, err := readHosts(r)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, host := range imported {
+		if !*keepTrust {
+			host.Trust, host.IntroducedBy = hosts.TOFU, nil
+			host.Introducer, host.Device = false, false
+		}
+		if *replace {
+			err = hosts.Replace(host)
+		} else {
+			err = hosts.Add(host)
+		}
+		var keyChange *hosts.KeyChangeError
+		if errors.As(err, &keyChange) {
+			util.Logf("%s", keyChange.Warning())
+			err = fmt.Errorf("%v; use -replace to accept the new keys", err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readHosts reads hosts in either the known hosts format or JSON.
+func readHosts(r io.Reader) ([]hosts.Host, error) {
+	br := bufio.NewReader(r)
+	if isJSON(br) {
+		var list []hosts.Host
+		err := json.NewDecoder(br).Decode(&list)
+		return list, err
+	}
+	return hosts.Read(br)
+}
+
+// isJSON reports whether the first non-space character of the input starts a JSON array.
+func isJSON(br *bufio.Reader) bool {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return false
+		} else if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			return b[0] == '['
+		}
+		br.ReadByte()
+	}
+}
+
+// hostsExport writes known hosts to stdout.
+// If names are given, only those hosts are exported.
+func hostsExport(args []string) error {
+	flags := flag.NewFlagSet("hosts export", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "print JSON")
+	flags.Parse(args)
+
+	knownHosts, err := hosts.Load()
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		knownHosts, err = selectHosts(knownHosts, flags.Args())
+		if err != nil {
+			return err
+		}
+	}
+
+	if *jsonFlag {
+		return printJSON(knownHosts)
+	}
+	return hosts.Write(os.Stdout, knownHosts)
+}
+
+// selectHosts returns the hosts with the given names or aliases, which may be hashed in the known hosts file.
+// Like hosts.Lookup, names take precedence over aliases.
+func selectHosts(knownHosts []hosts.Host, names []string) ([]hosts.Host, error) {
+	selected := make([]hosts.Host, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(knownHosts, func(host hosts.Host) bool { return host.HasName(name) })
+		if i < 0 {
+			i = slices.IndexFunc(knownHosts, func(host hosts.Host) bool { return host.HasAlias(name) })
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", hosts.ErrNoSuchHost, name)
+		}
+		selected = append(selected, knownHosts[i])
+	}
+	return selected, nil
+}
+
+// hostsHistory prints the history of changes to the keys in the known hosts file.
+// If a name is given, only the history of that host is printed.
+func hostsHistory(args []string) error {
+	flags := flag.NewFlagSet("hosts history", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "print JSON")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		return errors.New(hostsUsage)
+	}
+
+	events, err := hosts.History()
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 1 {
+		events = slices.DeleteFunc(events, func(event hosts.Event) bool {
+			return !event.HasName(flags.Arg(0))
+		})
+	}
+	if *jsonFlag {
+		return printJSON(events)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tEVENT\tNAME\tOLD FINGERPRINT\tNEW FINGERPRINT")
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			formatTime(event.Time), event.Kind, event.Name,
+			orDash(event.OldFingerprint), orDash(event.NewFingerprint))
+	}
+	return w.Flush()
+}
+
+// printFingerprint writes a fingerprint as a "fingerprint:" line of a tabwriter table.
+// Multi-line formats are written below the label.
+func printFingerprint(w io.Writer, fp fingerprint.Fingerprint, format fingerprint.Format) {
+	if format.Multiline() {
+		fmt.Fprintf(w, "fingerprint:\n%s\n", fp.Format(format))
+	} else {
+		fmt.Fprintf(w, "fingerprint:\t%s\n", fp.Format(format))
+	}
+}
+
+// printJSON writes a value to stdout as indented JSON.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+// list formats a list of strings for display.
+func list(items []string) string {
+	if len(items) < 1 {
+		return "-"
+	}
+	return strings.Join(items, ",")
+}
+
+// orDash returns s, or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatTime formats a timestamp for display.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
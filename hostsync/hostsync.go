@@ -0,0 +1,223 @@
+// Package hostsync synchronizes the known hosts of the devices of a user over an authenticated connection.
+//
+// One device serves and the other connects. Each sends its known hosts and history to the other,
+// signcrypted with its own keys to the other device's keys, and each merges what it received into its own known hosts.
+// Both devices must have marked the other as a device with hosts.SetDevice.
+package hostsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+	"io"
+	"net"
+	"time"
+
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/key"
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/util"
+)
+
+// Port is the default TCP port that devices sync on.
+const Port = 60323
+
+const (
+	network = "tcp"
+	timeout = 1 * time.Minute
+)
+
+// state is what each device sends to the other.
+type state struct {
+	Hosts   []hosts.Host  `json:"hosts"`
+	History []hosts.Event `json:"history"`
+}
+
+// Serve waits for another device to connect on a port and syncs the known hosts with it.
+// Replace accepts the other device's keys for hosts whose keys conflict.
+func Serve(port uint16, replace bool) error {
+	devices, err := loadDevices()
+	if err != nil {
+		return err
+	}
+
+	conn, err := hose_net.AcceptConnection(network, port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	util.Logf("accepted connection from %s", conn.RemoteAddr())
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	device, remote, err := receive(conn, devices)
+	if err != nil {
+		return err
+	}
+	util.Logf("syncing with %s", device.Name)
+	if err := send(conn, device); err != nil {
+		return err
+	}
+	return merge(remote, replace)
+}
+
+// Sync connects to another device on a port and syncs the known hosts with it.
+// Replace accepts the other device's keys for hosts whose keys conflict.
+func Sync(name string, port uint16, replace bool) error {
+	device, err := hosts.Lookup(name)
+	if err != nil {
+		return err
+	} else if !device.Device {
+		return fmt.Errorf("host %q is not one of your devices; mark it with \"hose hosts device %s\"", device.Name, device.Name)
+	}
+
+	conn, err := dial(device.Addrs, port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := send(conn, device); err != nil {
+		return err
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return err
+	}
+	_, remote, err := receive(conn, []hosts.Host{device})
+	if err != nil {
+		return err
+	}
+	return merge(remote, replace)
+}
+
+// loadDevices returns the known hosts that are devices of the user.
+func loadDevices() ([]hosts.Host, error) {
+	knownHosts, err := hosts.Load()
+	if err != nil {
+		return nil, err
+	}
+	var devices []hosts.Host
+	for _, host := range knownHosts {
+		if host.Device {
+			devices = append(devices, host)
+		}
+	}
+	if len(devices) < 1 {
+		return nil, errors.New("none of the known hosts are your devices; mark them with \"hose hosts device <name>\"")
+	}
+	return devices, nil
+}
+
+// send sends the local known hosts and history to a device.
+func send(conn net.Conn, device hosts.Host) error {
+	var local state
+	var err error
+	local.Hosts, err = hosts.Load()
+	if err != nil {
+		return err
+	}
+	local.History, err = hosts.History()
+	if err != nil {
+		return err
+	}
+
+	var keyCreator basic.EphemeralKeyCreator
+	sigKeypair, err := key.LoadSigKeypair()
+	if err != nil {
+		return err
+	}
+	sessionKey, err := key.NewReceiverSymmetricKey()
+	if err != nil {
+		return err
+	}
+	rcvrBoxKeys := []saltpack.BoxPublicKey{device.BoxPublicKey}
+	rcvrSymmetricKeys := []saltpack.ReceiverSymmetricKey{sessionKey}
+	plaintext, err := saltpack.NewSigncryptSealStream(conn, keyCreator, sigKeypair, rcvrBoxKeys, rcvrSymmetricKeys)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(plaintext).Encode(local); err != nil {
+		plaintext.Close()
+		return err
+	}
+	util.Logf("sent %d known hosts to %s", len(local.Hosts), device.Name)
+	return plaintext.Close()
+}
+
+// receive receives the known hosts and history of one of the given devices.
+// It returns the device that sent them.
+func receive(conn net.Conn, devices []hosts.Host) (hosts.Host, state, error) {
+	keyring := key.NewKeyring()
+	boxKeypair, err := key.LoadBoxKeypair()
+	if err != nil {
+		return hosts.Host{}, state{}, err
+	}
+	keyring.ImportBoxKeypair(boxKeypair)
+	for _, device := range devices {
+		keyring.ImportSigPublicKey(device.SigPublicKey)
+	}
+
+	senderPub, plaintext, err := saltpack.NewSigncryptOpenStream(conn, keyring, nil)
+	if err != nil {
+		return hosts.Host{}, state{}, err
+	}
+	device, err := identify(senderPub, devices)
+	if err != nil {
+		return hosts.Host{}, state{}, err
+	}
+	// Read the whole stream, so that a truncated stream is detected.
+	buf, err := io.ReadAll(io.LimitReader(plaintext, maxStateLen))
+	if err != nil {
+		return hosts.Host{}, state{}, err
+	}
+	var remote state
+	if err := json.Unmarshal(buf, &remote); err != nil {
+		return hosts.Host{}, state{}, err
+	}
+	util.Logf("received %d known hosts from %s", len(remote.Hosts), device.Name)
+	return device, remote, nil
+}
+
+// maxStateLen is the maximum length of the state received from a device.
+const maxStateLen = 64 << 20
+
+// identify returns the device that owns the key that signed a stream.
+func identify(senderPub saltpack.SigningPublicKey, devices []hosts.Host) (hosts.Host, error) {
+	if senderPub == nil {
+		return hosts.Host{}, errors.New("refusing stream from anonymous sender")
+	}
+	kid := senderPub.ToKID()
+	for _, device := range devices {
+		if string(kid) == string(device.SigPublicKey[:]) {
+			return device, nil
+		}
+	}
+	return hosts.Host{}, errors.New("stream is not from one of your devices")
+}
+
+// merge merges the known hosts of another device into the local known hosts.
+// The entry of the local host itself is skipped.
+func merge(remote state, replace bool) error {
+	self, err := key.LoadSigPublicKey()
+	if err != nil {
+		return err
+	}
+	others := make([]hosts.Host, 0, len(remote.Hosts))
+	for _, host := range remote.Hosts {
+		if host.SigPublicKey != self {
+			others = append(others, host)
+		}
+	}
+	return hosts.Merge(others, remote.History, replace)
+}
+
+// dial connects to the first reachable address of a device; see hose_net.Dial.
+func dial(addrs []string, port uint16) (net.Conn, error) {
+	if len(addrs) < 1 {
+		return nil, errors.New("no known address for the device")
+	}
+	return hose_net.Dial(context.Background(), network, addrs, port)
+}
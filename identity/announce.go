@@ -0,0 +1,82 @@
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// announceMagic starts an announcement. It cannot be mistaken for the start of a saltpack stream,
+// so a receiver can tell whether a sender announced itself before its stream.
+const announceMagic = "HOSE ANNOUNCE"
+
+// replyRequest follows announceMagic if the announcing host wants an announcement in return.
+const replyRequest = " REPLY"
+
+// maxAnnounceLen is the maximum length of a line of an announcement.
+const maxAnnounceLen = 4096
+
+// Announcement is a lightweight announcement of a host's identity, sent before a transfer.
+// Announcements let hosts that trust on first use learn each other's keys without a handshake.
+type Announcement struct {
+	Identity
+	Reply bool // the announcing host wants an announcement in return.
+}
+
+// Announce writes an announcement.
+func Announce(w io.Writer, a Announcement) error {
+	header := announceMagic
+	if a.Reply {
+		header += replyRequest
+	}
+	_, err := fmt.Fprintf(w, "%s\n%s\n", header, a.Identity)
+	return err
+}
+
+// ReadAnnouncement reads an announcement if there is one at the start of r.
+// If there is none, nothing is consumed from r and ok is false.
+func ReadAnnouncement(r *bufio.Reader) (a Announcement, ok bool, err error) {
+	magic, err := r.Peek(len(announceMagic))
+	if err != nil || !bytes.Equal(magic, []byte(announceMagic)) {
+		return Announcement{}, false, nil // no announcement.
+	}
+
+	header, err := readLine(r, maxAnnounceLen)
+	if err != nil {
+		return Announcement{}, false, fmt.Errorf("malformed announcement: %v", err)
+	}
+	switch header {
+	case announceMagic:
+	case announceMagic + replyRequest:
+		a.Reply = true
+	default:
+		return Announcement{}, false, fmt.Errorf("malformed announcement header %q", header)
+	}
+
+	line, err := readLine(r, maxAnnounceLen)
+	if err != nil {
+		return Announcement{}, false, fmt.Errorf("malformed announcement: %v", err)
+	}
+	a.Identity, err = Parse(line)
+	if err != nil {
+		return Announcement{}, false, err
+	}
+	return a, true, nil
+}
+
+// readLine reads a line of at most max bytes, without the newline.
+func readLine(r *bufio.Reader, max int) (string, error) {
+	var line strings.Builder
+	for line.Len() <= max {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		} else if b == '\n' {
+			return line.String(), nil
+		}
+		line.WriteByte(b)
+	}
+	return "", fmt.Errorf("line too long")
+}
@@ -0,0 +1,102 @@
+package identity
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"git.samanthony.xyz/hose/key"
+)
+
+// bundleVersion is the version of the bundle format.
+const bundleVersion = 1
+
+// signaturePrefix is prepended to the encoded identity before it is signed,
+// so that the signature cannot be confused with a signature of anything else.
+const signaturePrefix = "hose identity bundle v1\n"
+
+var errBadSignature = errors.New("identity bundle has an invalid signature")
+
+// Bundle is an identity that is signed with the host's own signing key.
+// The signature proves that the bundle was created by the owner of the signing key,
+// and that the name, addresses and encryption key were not tampered with.
+type Bundle struct {
+	Identity
+	Signature []byte
+}
+
+// jsonBundle is the JSON representation of a Bundle.
+type jsonBundle struct {
+	Version   int      `json:"version"`
+	Name      string   `json:"name,omitempty"`
+	Addrs     []string `json:"addresses,omitempty"`
+	BoxKey    string   `json:"box_key"`
+	SigKey    string   `json:"sig_key"`
+	Signature string   `json:"signature"`
+}
+
+// Sign signs the identity with a signing keypair, which must belong to the identity.
+func (id Identity) Sign(keypair key.SigKeypair) (Bundle, error) {
+	if keypair.Public() != id.SigPublicKey {
+		return Bundle{}, fmt.Errorf("signing key does not belong to the identity")
+	}
+	sig, err := keypair.Sign(signedMessage(id))
+	if err != nil {
+		return Bundle{}, err
+	}
+	return Bundle{id, sig}, nil
+}
+
+// Verify returns a non-nil error if the bundle's signature is not valid.
+func (b Bundle) Verify() error {
+	if err := b.SigPublicKey.Verify(signedMessage(b.Identity), b.Signature); err != nil {
+		return errBadSignature
+	}
+	return nil
+}
+
+// signedMessage returns the message that is signed in a bundle.
+func signedMessage(id Identity) []byte {
+	return []byte(signaturePrefix + id.String())
+}
+
+func (b Bundle) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonBundle{
+		Version:   bundleVersion,
+		Name:      b.Name,
+		Addrs:     b.Addrs,
+		BoxKey:    hex.EncodeToString(b.BoxPublicKey[:]),
+		SigKey:    hex.EncodeToString(b.SigPublicKey[:]),
+		Signature: hex.EncodeToString(b.Signature),
+	})
+}
+
+// UnmarshalJSON decodes a bundle encoded by MarshalJSON.
+// It does not verify the signature.
+func (b *Bundle) UnmarshalJSON(buf []byte) error {
+	var j jsonBundle
+	if err := json.Unmarshal(buf, &j); err != nil {
+		return err
+	}
+	if j.Version != bundleVersion {
+		return fmt.Errorf("unsupported identity bundle version %d", j.Version)
+	}
+	if j.Name != "" && !ValidName(j.Name) {
+		return fmt.Errorf("invalid host name %q", j.Name)
+	}
+	boxPubKey, err := key.DecodeBoxPublicKey([]byte(j.BoxKey))
+	if err != nil {
+		return err
+	}
+	sigPubKey, err := key.DecodeSigPublicKey([]byte(j.SigKey))
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(j.Signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+	*b = Bundle{Identity{j.Name, j.Addrs, boxPubKey, sigPubKey}, sig}
+	return nil
+}
@@ -0,0 +1,123 @@
+package identity
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/key"
+)
+
+// scheme is the URI scheme of an identity encoded as text.
+const scheme = "hose"
+
+// MaxNameLen is the maximum length of the name of a host.
+const MaxNameLen = 255
+
+// Identity is the public part of a host's identity: its name, addresses and public keys.
+// It is what hosts exchange so that they can recognize each other.
+type Identity struct {
+	Name  string   // name of the host; may be empty.
+	Addrs []string // addresses that the host may be reachable at.
+	key.BoxPublicKey
+	key.SigPublicKey
+}
+
+// Local returns the identity of the local host, reachable at the given addresses.
+func Local(addrs []string) (Identity, error) {
+	boxPubKey, err := key.LoadBoxPublicKey()
+	if err != nil {
+		return Identity{}, err
+	}
+	sigPubKey, err := key.LoadSigPublicKey()
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{LocalName(), addrs, boxPubKey, sigPubKey}, nil
+}
+
+// LocalName returns the name that the local host announces to remote hosts, or "" if it has none.
+func LocalName() string {
+	name, err := os.Hostname()
+	if err != nil || !ValidName(name) {
+		return ""
+	}
+	return name
+}
+
+// ValidName reports whether a name announced by a host can be used as the name of a host.
+func ValidName(name string) bool {
+	return len(name) <= MaxNameLen && hosts.ValidateName(name) == nil
+}
+
+// LocalAddrs returns the unicast addresses of the local host's network interfaces, excluding loopback addresses.
+func LocalAddrs() ([]string, error) {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ifaceAddrs))
+	for _, ifaceAddr := range ifaceAddrs {
+		ipnet, ok := ifaceAddr.(*net.IPNet)
+		if ok && ipnet.IP.IsGlobalUnicast() {
+			addrs = append(addrs, ipnet.IP.String())
+		}
+	}
+	return addrs, nil
+}
+
+// Host returns a known hosts entry for the identity, saved under the given name.
+func (id Identity) Host(name string) hosts.Host {
+	return hosts.Host{
+		Name:         name,
+		Addrs:        id.Addrs,
+		BoxPublicKey: id.BoxPublicKey,
+		SigPublicKey: id.SigPublicKey,
+	}
+}
+
+// String encodes the identity as a URI of the form
+// "hose:?name=NAME&addr=ADDR&box=BOXKEY&sig=SIGKEY", with one addr parameter per address.
+func (id Identity) String() string {
+	query := url.Values{}
+	if id.Name != "" {
+		query.Set("name", id.Name)
+	}
+	for _, addr := range id.Addrs {
+		query.Add("addr", addr)
+	}
+	query.Set("box", fmt.Sprintf("%x", id.BoxPublicKey))
+	query.Set("sig", fmt.Sprintf("%x", id.SigPublicKey))
+	return (&url.URL{Scheme: scheme, RawQuery: query.Encode()}).String()
+}
+
+// Parse decodes an identity encoded by String.
+func Parse(s string) (Identity, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return Identity{}, err
+	} else if u.Scheme != scheme {
+		return Identity{}, fmt.Errorf("not a hose identity: %q", s)
+	}
+	query := u.Query()
+
+	var id Identity
+	if name := query.Get("name"); name != "" {
+		if !ValidName(name) {
+			return Identity{}, fmt.Errorf("invalid host name %q", name)
+		}
+		id.Name = name
+	}
+	id.Addrs = query["addr"]
+	id.BoxPublicKey, err = key.DecodeBoxPublicKey([]byte(query.Get("box")))
+	if err != nil {
+		return Identity{}, err
+	}
+	id.SigPublicKey, err = key.DecodeSigPublicKey([]byte(query.Get("sig")))
+	if err != nil {
+		return Identity{}, err
+	}
+	return id, nil
+}
@@ -0,0 +1,110 @@
+package identity
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"git.samanthony.xyz/hose/key"
+)
+
+// introductionVersion is the version of the introduction format.
+const introductionVersion = 1
+
+// introductionPrefix is prepended to the introducer's key and the encoded identity before they are signed,
+// so that the signature cannot be confused with a signature of anything else.
+const introductionPrefix = "hose introduction v1\n"
+
+var errBadIntroduction = errors.New("introduction has an invalid signature")
+
+// Introduction is an identity that is signed by another host, the introducer.
+// The signature means "I vouch that these keys belong to this host".
+type Introduction struct {
+	Identity
+	Introducer key.SigPublicKey // signature verification key of the introducer.
+	Signature  []byte
+}
+
+// jsonIntroduction is the JSON representation of an Introduction.
+type jsonIntroduction struct {
+	Version    int      `json:"version"`
+	Name       string   `json:"name"`
+	Addrs      []string `json:"addresses,omitempty"`
+	BoxKey     string   `json:"box_key"`
+	SigKey     string   `json:"sig_key"`
+	Introducer string   `json:"introducer"`
+	Signature  string   `json:"signature"`
+}
+
+// Introduce vouches for the identity of another host by signing it with the introducer's signing keypair.
+func (id Identity) Introduce(keypair key.SigKeypair) (Introduction, error) {
+	if id.Name == "" {
+		return Introduction{}, fmt.Errorf("cannot introduce a host without a name")
+	}
+	introducer := keypair.Public()
+	sig, err := keypair.Sign(introductionMessage(id, introducer))
+	if err != nil {
+		return Introduction{}, err
+	}
+	return Introduction{id, introducer, sig}, nil
+}
+
+// Verify returns a non-nil error if the introduction's signature is not valid.
+// It does not check whether the introducer is trusted.
+func (in Introduction) Verify() error {
+	if err := in.Introducer.Verify(introductionMessage(in.Identity, in.Introducer), in.Signature); err != nil {
+		return errBadIntroduction
+	}
+	return nil
+}
+
+// introductionMessage returns the message that is signed in an introduction.
+func introductionMessage(id Identity, introducer key.SigPublicKey) []byte {
+	return []byte(fmt.Sprintf("%s%x\n%s", introductionPrefix, introducer, id))
+}
+
+func (in Introduction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonIntroduction{
+		Version:    introductionVersion,
+		Name:       in.Name,
+		Addrs:      in.Addrs,
+		BoxKey:     hex.EncodeToString(in.BoxPublicKey[:]),
+		SigKey:     hex.EncodeToString(in.SigPublicKey[:]),
+		Introducer: hex.EncodeToString(in.Introducer[:]),
+		Signature:  hex.EncodeToString(in.Signature),
+	})
+}
+
+// UnmarshalJSON decodes an introduction encoded by MarshalJSON.
+// It does not verify the signature.
+func (in *Introduction) UnmarshalJSON(buf []byte) error {
+	var j jsonIntroduction
+	if err := json.Unmarshal(buf, &j); err != nil {
+		return err
+	}
+	if j.Version != introductionVersion {
+		return fmt.Errorf("unsupported introduction version %d", j.Version)
+	}
+	if !ValidName(j.Name) {
+		return fmt.Errorf("invalid host name %q", j.Name)
+	}
+	boxPubKey, err := key.DecodeBoxPublicKey([]byte(j.BoxKey))
+	if err != nil {
+		return err
+	}
+	sigPubKey, err := key.DecodeSigPublicKey([]byte(j.SigKey))
+	if err != nil {
+		return err
+	}
+	introducer, err := key.DecodeSigPublicKey([]byte(j.Introducer))
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(j.Signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+	*in = Introduction{Identity{j.Name, j.Addrs, boxPubKey, sigPubKey}, introducer, sig}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/handshake"
+	"git.samanthony.xyz/hose/identity"
+	"git.samanthony.xyz/hose/key"
+	"git.samanthony.xyz/hose/util"
+)
+
+const (
+	exportIdentityUsage = "Usage: hose export-identity [-name <name>] [-addr <addr,...>]"
+	importIdentityUsage = "Usage: hose import-identity [-expect-fingerprint <fingerprint>] [-name <name>] [-alias <alias,...>] [-replace] [file]"
+)
+
+// exportIdentity writes the local identity to stdout as a signed identity bundle.
+func exportIdentity(args []string) error {
+	flags := flag.NewFlagSet("export-identity", flag.ExitOnError)
+	name := flags.String("name", "", "name to include in the bundle (default: the host name)")
+	addrs := flags.String("addr", "", "comma-separated addresses to include in the bundle")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(exportIdentityUsage)
+	}
+
+	id, err := identity.Local(splitList(*addrs))
+	if err != nil {
+		return err
+	}
+	if *name != "" {
+		if !identity.ValidName(*name) {
+			return errors.New("invalid host name " + *name)
+		}
+		id.Name = *name
+	}
+
+	keypair, err := key.LoadSigKeypair()
+	if err != nil {
+		return err
+	}
+	bundle, err := id.Sign(keypair)
+	if err != nil {
+		return err
+	}
+	return printJSON(bundle)
+}
+
+// importIdentity adds the identity in a signed identity bundle to the known hosts file without asking the user.
+// The bundle is read from a file, or from stdin if no file is given.
+func importIdentity(args []string) error {
+	flags := flag.NewFlagSet("import-identity", flag.ExitOnError)
+	expect := flags.String("expect-fingerprint", "", "refuse the bundle unless its keys have this fingerprint (in hex format)")
+	name := flags.String("name", "", "name to save the host under (default: the name in the bundle)")
+	aliases := flags.String("alias", "", "comma-separated aliases of the host")
+	replace := flags.Bool("replace", false, "accept changed keys of a known host")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	default:
+		return errors.New(importIdentityUsage)
+	}
+
+	var bundle identity.Bundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return err
+	}
+	if err := bundle.Verify(); err != nil {
+		return err
+	}
+
+	opts := handshake.Options{
+		Name:    *name,
+		Aliases: splitList(*aliases),
+		Replace: *replace,
+		Trust:   true,
+	}
+	if *expect != "" {
+		fp, err := fingerprint.Parse(*expect)
+		if err != nil {
+			return err
+		}
+		opts.Expect = &fp
+	} else {
+		util.Logf("warning: importing identity without verifying its fingerprint; it is saved as trusted on first use. Use -expect-fingerprint to verify it")
+	}
+	return handshake.Import(bundle.Identity, opts)
+}
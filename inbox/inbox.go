@@ -0,0 +1,263 @@
+// Package inbox keeps the transfers that were received while the user was not there to read them.
+//
+// Each item is stored in the inbox directory of the selected profile as two files:
+// "<id>.data" holds the received data, and "<id>.json" describes who sent it and when.
+// Items are numbered in the order they were received, and the number of a deleted item is not used again;
+// the next number is kept in the file "next". An item only appears in the inbox
+// once its data was completely received and verified.
+package inbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/profile"
+)
+
+const (
+	dataExt     = ".data"
+	metaExt     = ".json"
+	tmpPrefix   = ".tmp-"
+	counterName = "next" // file that holds the next ID.
+)
+
+// ErrNoSuchItem is returned when an item is not in the inbox.
+var ErrNoSuchItem = errors.New("no such item")
+
+// Item describes a transfer in the inbox.
+type Item struct {
+	ID          int
+	From        string                  // name of the sender in the known hosts file when the transfer was received.
+	Fingerprint fingerprint.Fingerprint // fingerprint of the sender's keys.
+	Addr        string                  // address that the sender connected from, if known.
+	Received    time.Time
+	Size        int64
+}
+
+// jsonItem is the encoding of an item's description.
+type jsonItem struct {
+	From        string    `json:"from"`
+	Fingerprint string    `json:"fingerprint"`
+	Addr        string    `json:"addr,omitempty"`
+	Received    time.Time `json:"received"`
+	Size        int64     `json:"size"`
+}
+
+// Writer writes the data of a new item. The item is added to the inbox by Commit.
+type Writer struct {
+	f *os.File
+}
+
+// dir returns the inbox directory of the selected profile.
+func dir() string {
+	return profile.Path("inbox")
+}
+
+// Create starts a new item.
+// The caller must call Commit once the data is written, or Discard if it is not wanted.
+func Create() (*Writer, error) {
+	if err := os.MkdirAll(dir(), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir(), tmpPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{f}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+// Discard deletes the data that was written.
+func (w *Writer) Discard() error {
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+// Commit adds the item to the inbox under a new ID and returns it.
+// The ID and size of the item are filled in.
+func (w *Writer) Commit(item Item) (Item, error) {
+	defer os.Remove(w.f.Name()) // no-op once linked.
+	defer w.f.Close()
+	if err := w.f.Sync(); err != nil {
+		return Item{}, err
+	}
+	info, err := w.f.Stat()
+	if err != nil {
+		return Item{}, err
+	}
+	item.Size = info.Size()
+
+	item.ID, err = claimID(w.f.Name())
+	if err != nil {
+		return Item{}, err
+	}
+
+	data, err := json.MarshalIndent(jsonItem{item.From, item.Fingerprint.String(), item.Addr, item.Received, item.Size}, "", "\t")
+	if err != nil {
+		return Item{}, err
+	}
+	if err := writeFile(path(item.ID, metaExt), append(data, '\n')); err != nil {
+		os.Remove(path(item.ID, dataExt))
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// claimID links the data of a new item under the next ID, and returns the ID.
+// The counter is advanced past it, so that IDs of deleted items are not used again.
+func claimID(name string) (int, error) {
+	f, err := os.OpenFile(filepath.Join(dir(), counterName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := lock(f); err != nil {
+		return 0, err
+	}
+	defer unlock(f)
+
+	id, err := readCounter(f)
+	if err != nil {
+		return 0, err
+	}
+	// Linking fails if the ID was claimed by a process that does not hold the lock.
+	for {
+		err := os.Link(name, path(id, dataExt))
+		if err == nil {
+			break
+		} else if !errors.Is(err, os.ErrExist) {
+			return 0, err
+		}
+		id++
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(id+1)+"\n"), 0); err != nil {
+		return 0, err
+	}
+	return id, f.Sync()
+}
+
+// readCounter reads the next ID from the counter file.
+// If the file is empty, e.g. if the inbox was created by an older version of hose, the next ID follows the last item.
+func readCounter(f *os.File) (int, error) {
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	if s := strings.TrimSpace(string(buf)); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil || id < 1 {
+			return 0, fmt.Errorf("malformed inbox counter %s: %q", f.Name(), s)
+		}
+		return id, nil
+	}
+	items, err := List()
+	if err != nil {
+		return 0, err
+	} else if len(items) > 0 {
+		return items[len(items)-1].ID + 1, nil
+	}
+	return 1, nil
+}
+
+// List returns the items in the inbox in the order they were received.
+func List() ([]Item, error) {
+	entries, err := os.ReadDir(dir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), metaExt)
+		if !ok || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			continue // not an item.
+		}
+		item, err := Lookup(id)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b Item) int { return a.ID - b.ID })
+	return items, nil
+}
+
+// Lookup returns the description of an item.
+func Lookup(id int) (Item, error) {
+	data, err := os.ReadFile(path(id, metaExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return Item{}, fmt.Errorf("%w: %d", ErrNoSuchItem, id)
+	} else if err != nil {
+		return Item{}, err
+	}
+	var ji jsonItem
+	if err := json.Unmarshal(data, &ji); err != nil {
+		return Item{}, fmt.Errorf("item %d: %v", id, err)
+	}
+	fp, err := fingerprint.Parse(ji.Fingerprint)
+	if err != nil {
+		return Item{}, fmt.Errorf("item %d: %v", id, err)
+	}
+	return Item{id, ji.From, fp, ji.Addr, ji.Received, ji.Size}, nil
+}
+
+// Open opens the data of an item for reading.
+func Open(id int) (io.ReadCloser, error) {
+	if _, err := Lookup(id); err != nil {
+		return nil, err
+	}
+	return os.Open(path(id, dataExt))
+}
+
+// Delete removes an item from the inbox.
+func Delete(id int) error {
+	if err := os.Remove(path(id, metaExt)); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %d", ErrNoSuchItem, id)
+	} else if err != nil {
+		return err
+	}
+	return os.Remove(path(id, dataExt))
+}
+
+// path returns the path of a file of an item.
+func path(id int, ext string) string {
+	return filepath.Join(dir(), strconv.Itoa(id)+ext)
+}
+
+// writeFile writes a file by renaming a temporary file, so that it is never seen partially written.
+func writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
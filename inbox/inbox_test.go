@@ -0,0 +1,96 @@
+package inbox
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.samanthony.xyz/hose/profile"
+)
+
+// useTempDir makes the inbox of the test live in a temporary directory.
+func useTempDir(t *testing.T) {
+	profile.SetDataDir(t.TempDir())
+	if err := profile.Select(profile.Default); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// add adds an item with some data to the inbox.
+func add(t *testing.T, data string) Item {
+	t.Helper()
+	w, err := Create()
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	item, err := w.Commit(Item{From: "bob", Received: time.Now()})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return item
+}
+
+func TestCommit(t *testing.T) {
+	useTempDir(t)
+	item := add(t, "hello")
+	if item.ID != 1 || item.Size != 5 {
+		t.Errorf("ID, size = %d, %d; want 1, 5", item.ID, item.Size)
+	}
+	r, err := Open(item.ID)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	if data, err := io.ReadAll(r); err != nil || string(data) != "hello" {
+		t.Errorf("data = %q, %v; want \"hello\"", data, err)
+	}
+	if items, err := List(); err != nil || len(items) != 1 || items[0].From != "bob" {
+		t.Errorf("List = %v, %v; want item 1 from bob", items, err)
+	}
+}
+
+func TestIDsNotReused(t *testing.T) {
+	useTempDir(t)
+	add(t, "a")
+	second := add(t, "b")
+	if err := Delete(second.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if third := add(t, "c"); third.ID != 3 {
+		t.Errorf("ID after deleting the last item = %d; want 3", third.ID)
+	}
+}
+
+func TestCounterMissing(t *testing.T) {
+	useTempDir(t)
+	add(t, "a")
+	add(t, "b")
+	// An inbox of an older version of hose has no counter.
+	if err := os.Remove(filepath.Join(dir(), counterName)); err != nil {
+		t.Fatal(err)
+	}
+	if item := add(t, "c"); item.ID != 3 {
+		t.Errorf("ID without a counter = %d; want 3", item.ID)
+	}
+}
+
+func TestCounterMalformed(t *testing.T) {
+	useTempDir(t)
+	add(t, "a")
+	if err := os.WriteFile(filepath.Join(dir(), counterName), []byte("x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Create()
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := w.Commit(Item{}); err == nil || !strings.Contains(err.Error(), "malformed inbox counter") {
+		t.Errorf("Commit error = %v; want malformed inbox counter", err)
+	}
+}
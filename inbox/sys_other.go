@@ -0,0 +1,15 @@
+//go:build !unix
+
+package inbox
+
+import "os"
+
+// lock does nothing on systems without flock(2), where IDs are only claimed by linking the data.
+func lock(f *os.File) error {
+	return nil
+}
+
+// unlock does nothing on systems without flock(2).
+func unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package inbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// lock waits for an exclusive lock on a file.
+func lock(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlock releases a lock acquired by lock.
+func unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tonistiigi/units"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"git.samanthony.xyz/hose/inbox"
+)
+
+const inboxUsage = "Usage: hose inbox <list | cat <id> | save <id> <file> | delete <id...>>"
+
+// inboxCommands are the subcommands of "hose inbox".
+var inboxCommands = map[string]func(args []string) error{
+	"list":   inboxList,
+	"cat":    inboxCat,
+	"save":   inboxSave,
+	"delete": inboxDelete,
+}
+
+// inboxCmd inspects the transfers that were received with "hose -r -spool".
+// Without a subcommand, it lists them.
+func inboxCmd(args []string) error {
+	if len(args) < 1 {
+		return inboxList(nil)
+	}
+	cmd, ok := inboxCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], inboxUsage)
+	}
+	return cmd(args[1:])
+}
+
+// inboxList prints a summary of every item in the inbox.
+func inboxList(args []string) error {
+	if len(args) > 0 {
+		return errors.New(inboxUsage)
+	}
+	items, err := inbox.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFROM\tRECEIVED\tSIZE")
+	for _, item := range items {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%#.2f\n", item.ID, item.From, formatTime(item.Received), units.Bytes(item.Size)*units.B)
+	}
+	return w.Flush()
+}
+
+// inboxCat writes the data of an item to stdout.
+func inboxCat(args []string) error {
+	if len(args) != 1 {
+		return errors.New(inboxUsage)
+	}
+	id, err := parseItemID(args[0])
+	if err != nil {
+		return err
+	}
+	r, err := inbox.Open(id)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(os.Stdout, r)
+	return err
+}
+
+// inboxSave copies the data of an item to a file. It refuses to overwrite an existing file.
+func inboxSave(args []string) error {
+	if len(args) != 2 {
+		return errors.New(inboxUsage)
+	}
+	id, err := parseItemID(args[0])
+	if err != nil {
+		return err
+	}
+	r, err := inbox.Open(id)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// inboxDelete removes items from the inbox.
+func inboxDelete(args []string) error {
+	if len(args) < 1 {
+		return errors.New(inboxUsage)
+	}
+	for _, arg := range args {
+		id, err := parseItemID(arg)
+		if err != nil {
+			return err
+		}
+		if err := inbox.Delete(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseItemID parses the ID of an inbox item.
+func parseItemID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid item ID %q", s)
+	}
+	return id, nil
+}
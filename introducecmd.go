@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"git.samanthony.xyz/hose/handshake"
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/identity"
+	"git.samanthony.xyz/hose/key"
+)
+
+const (
+	introduceUsage          = "Usage: hose introduce [-name <name>] <host>"
+	importIntroductionUsage = "Usage: hose import-introduction [-name <name>] [-alias <alias,...>] [-replace] [file]"
+)
+
+// introduce writes an introduction of a known host to stdout, vouching for its keys.
+// Only hosts whose keys were verified by the user can be introduced.
+func introduce(args []string) error {
+	flags := flag.NewFlagSet("introduce", flag.ExitOnError)
+	name := flags.String("name", "", "name to introduce the host by (default: its name in the known hosts file)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New(introduceUsage)
+	}
+
+	host, err := hosts.Lookup(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if host.Trust != hosts.Verified {
+		return fmt.Errorf("the keys of host %q are not verified (trust: %s); do a handshake with it first", host.Name, host.Trust)
+	}
+	id := identity.Identity{
+		Name:         host.Name,
+		Addrs:        host.Addrs,
+		BoxPublicKey: host.BoxPublicKey,
+		SigPublicKey: host.SigPublicKey,
+	}
+	if *name != "" {
+		if !identity.ValidName(*name) {
+			return errors.New("invalid host name " + *name)
+		}
+		id.Name = *name
+	}
+
+	keypair, err := key.LoadSigKeypair()
+	if err != nil {
+		return err
+	}
+	introduction, err := id.Introduce(keypair)
+	if err != nil {
+		return err
+	}
+	return printJSON(introduction)
+}
+
+// importIntroduction adds a host introduced by a trusted introducer to the known hosts file without asking the user.
+// The introduction is read from a file, or from stdin if no file is given.
+// The introduction chain is recorded in the known hosts file.
+func importIntroduction(args []string) error {
+	flags := flag.NewFlagSet("import-introduction", flag.ExitOnError)
+	name := flags.String("name", "", "name to save the host under (default: the name in the introduction)")
+	aliases := flags.String("alias", "", "comma-separated aliases of the host")
+	replace := flags.Bool("replace", false, "accept changed keys of a known host")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	default:
+		return errors.New(importIntroductionUsage)
+	}
+
+	var introduction identity.Introduction
+	if err := json.NewDecoder(r).Decode(&introduction); err != nil {
+		return err
+	}
+	if err := introduction.Verify(); err != nil {
+		return err
+	}
+
+	introducer, err := hosts.LookupSigPublicKey(introduction.Introducer)
+	if errors.Is(err, hosts.ErrNoSuchHost) {
+		return fmt.Errorf("introduction is signed by an unknown host")
+	} else if err != nil {
+		return err
+	} else if !introducer.Introducer {
+		return fmt.Errorf("host %q is not trusted to introduce other hosts; trust it with \"hose hosts introducer %s\"",
+			introducer.Name, introducer.Name)
+	}
+
+	return handshake.Import(introduction.Identity, handshake.Options{
+		Name:         *name,
+		Aliases:      splitList(*aliases),
+		Replace:      *replace,
+		Trust:        true,
+		IntroducedBy: append(introducer.IntroducedBy, introducer.Fingerprint()),
+	})
+}
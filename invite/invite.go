@@ -0,0 +1,242 @@
+package invite
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/identity"
+	"git.samanthony.xyz/hose/profile"
+)
+
+// scheme is the URI scheme of an invitation token.
+const scheme = "hose-invite"
+
+// secretSize is the length of an invitation's secret in bytes.
+const secretSize = 32
+
+const (
+	dirMode  os.FileMode = 0755
+	fileMode os.FileMode = 0600 // the invites file contains secrets.
+)
+
+var errNoSuchInvite = errors.New("no such invitation")
+
+// Invite is a pending invitation that the inviting host keeps until it is redeemed or expires.
+type Invite struct {
+	Secret  []byte    `json:"secret"`
+	Name    string    `json:"name,omitempty"` // name to save the invited host under; if empty, the name it announces.
+	Expires time.Time `json:"expires"`
+}
+
+// Token is what the inviting host gives to the invited host.
+// It is encoded as text with String, and should be kept secret until it is redeemed.
+type Token struct {
+	Addrs       []string                // addresses of the inviting host.
+	Fingerprint fingerprint.Fingerprint // fingerprint of the inviting host's keys.
+	Secret      []byte                  // shared secret that authenticates the invited host.
+}
+
+// invitesFile returns the path of the selected profile's pending invitations.
+func invitesFile() string {
+	return profile.Path("invites")
+}
+
+// Create creates a new invitation that expires after the given duration,
+// and returns the token to give to the invited host.
+// The invited host will be saved under the given name, or under the name it announces if name is empty.
+func Create(addrs []string, name string, ttl time.Duration) (Token, error) {
+	if name != "" {
+		if err := hosts.ValidateName(name); err != nil {
+			return Token{}, err
+		}
+	}
+	local, err := identity.Local(addrs)
+	if err != nil {
+		return Token{}, err
+	}
+
+	secret := make([]byte, secretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return Token{}, err
+	}
+
+	err = locked(func() error {
+		invites, err := Load()
+		if err != nil {
+			return err
+		}
+		invites = append(invites, Invite{secret, name, time.Now().Add(ttl).UTC().Truncate(time.Second)})
+		return store(invites)
+	})
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{addrs, fingerprint.Of(local.BoxPublicKey, local.SigPublicKey), secret}, nil
+}
+
+// ID returns the identifier of an invitation, which is derived from its secret.
+// It is sent in the clear so that the inviting host can find the invitation.
+func (inv Invite) ID() string {
+	return id(inv.Secret)
+}
+
+// ID returns the identifier of the invitation that the token redeems.
+func (t Token) ID() string {
+	return id(t.Secret)
+}
+
+func id(secret []byte) string {
+	sum := sha256.Sum256(append([]byte("hose invite id\n"), secret...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Expired reports whether the invitation can no longer be redeemed.
+func (inv Invite) Expired() bool {
+	return time.Now().After(inv.Expires)
+}
+
+// Load reads the pending invitations from disc, discarding expired ones.
+func Load() ([]Invite, error) {
+	invites := make([]Invite, 0)
+	f, err := os.Open(invitesFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return invites, nil // no invitations yet.
+	} else if err != nil {
+		return invites, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&invites); err != nil {
+		return invites, fmt.Errorf("error parsing invitations file: %s: %v", invitesFile(), err)
+	}
+	return slices.DeleteFunc(invites, Invite.Expired), nil
+}
+
+// Revoke deletes a pending invitation.
+func Revoke(id string) error {
+	return locked(func() error { return remove(id) })
+}
+
+// lookup returns the pending invitation with the given ID.
+func lookup(id string) (Invite, error) {
+	invites, err := Load()
+	if err != nil {
+		return Invite{}, err
+	}
+	i := slices.IndexFunc(invites, func(inv Invite) bool { return inv.ID() == id })
+	if i < 0 {
+		return Invite{}, fmt.Errorf("%w: %s", errNoSuchInvite, id)
+	}
+	return invites[i], nil
+}
+
+// remove deletes a pending invitation, so that it cannot be redeemed again.
+// The caller must hold the lock.
+func remove(id string) error {
+	invites, err := Load()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(invites, func(inv Invite) bool { return inv.ID() == id })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", errNoSuchInvite, id)
+	}
+	return store(slices.Delete(invites, i, i+1))
+}
+
+// store writes the pending invitations to disc. It atomically replaces the entire file.
+// The caller must hold the lock.
+func store(invites []Invite) error {
+	buf, err := json.Marshal(invites)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(invitesFile()), dirMode); err != nil {
+		return err
+	}
+	return writeFile(invitesFile(), append(buf, '\n'), fileMode)
+}
+
+// writeFile atomically replaces a file with the given data.
+// The data is written to a temporary file in the same directory, synced to disc, and renamed to the file.
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // in case of failure.
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// String encodes the token as a URI of the form "hose-invite:?addr=ADDR&fp=FINGERPRINT&secret=SECRET".
+func (t Token) String() string {
+	query := url.Values{}
+	for _, addr := range t.Addrs {
+		query.Add("addr", addr)
+	}
+	query.Set("fp", hex.EncodeToString(t.Fingerprint[:]))
+	query.Set("secret", hex.EncodeToString(t.Secret))
+	return (&url.URL{Scheme: scheme, RawQuery: query.Encode()}).String()
+}
+
+// ParseToken decodes a token encoded by String.
+func ParseToken(s string) (Token, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return Token{}, err
+	} else if u.Scheme != scheme {
+		return Token{}, fmt.Errorf("not a hose invitation: %q", s)
+	}
+	query := u.Query()
+
+	var t Token
+	t.Addrs = query["addr"]
+	if len(t.Addrs) < 1 {
+		return Token{}, fmt.Errorf("invitation has no address")
+	}
+	t.Fingerprint, err = fingerprint.Parse(query.Get("fp"))
+	if err != nil {
+		return Token{}, err
+	}
+	t.Secret, err = hex.DecodeString(query.Get("secret"))
+	if err != nil {
+		return Token{}, fmt.Errorf("malformed invitation secret: %v", err)
+	} else if len(t.Secret) != secretSize {
+		return Token{}, fmt.Errorf("malformed invitation secret: expected %d bytes; got %d", secretSize, len(t.Secret))
+	}
+	return t, nil
+}
@@ -0,0 +1,55 @@
+package invite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"git.samanthony.xyz/hose/fingerprint"
+)
+
+func TestToken(t *testing.T) {
+	fp, err := fingerprint.Parse(strings.Repeat("ab", fingerprint.Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := []Token{
+		{[]string{"10.0.0.1"}, fp, bytes.Repeat([]byte{1}, secretSize)},
+		{[]string{"10.0.0.1", "fe80::1%eth0", "bob.example.com"}, fp, bytes.Repeat([]byte{2}, secretSize)},
+	}
+	for _, token := range tokens {
+		s := token.String()
+		if !strings.HasPrefix(s, scheme+":") {
+			t.Errorf("token %q does not start with %s:", s, scheme)
+		}
+		got, err := ParseToken(s)
+		if err != nil {
+			t.Errorf("ParseToken(%q): %v", s, err)
+			continue
+		}
+		if strings.Join(got.Addrs, " ") != strings.Join(token.Addrs, " ") || got.Fingerprint != token.Fingerprint || !bytes.Equal(got.Secret, token.Secret) {
+			t.Errorf("ParseToken(%q) = %v; want %v", s, got, token)
+		}
+		if got.ID() != token.ID() {
+			t.Errorf("ParseToken(%q).ID() = %s; want %s", s, got.ID(), token.ID())
+		}
+	}
+}
+
+func TestParseTokenErrors(t *testing.T) {
+	fp := strings.Repeat("ab", fingerprint.Size)
+	secret := strings.Repeat("01", secretSize)
+	tests := []struct{ token, err string }{
+		{"https://example.com/?addr=10.0.0.1", "not a hose invitation"},
+		{"hose-invite:?fp=" + fp + "&secret=" + secret, "invitation has no address"},
+		{"hose-invite:?addr=10.0.0.1&fp=xyz&secret=" + secret, "malformed fingerprint"},
+		{"hose-invite:?addr=10.0.0.1&fp=" + fp + "&secret=xyz", "malformed invitation secret"},
+		{"hose-invite:?addr=10.0.0.1&fp=" + fp + "&secret=0102", "malformed invitation secret: expected 32 bytes; got 2"},
+	}
+	for _, test := range tests {
+		_, err := ParseToken(test.token)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ParseToken(%q) error = %v; want %q", test.token, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package invite
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"git.samanthony.xyz/hose/handshake"
+	"git.samanthony.xyz/hose/identity"
+	"git.samanthony.xyz/hose/key"
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/util"
+)
+
+const (
+	network = "tcp"
+	timeout = 1 * time.Minute
+)
+
+// Roles of the messages of a redemption.
+const (
+	redeemRole = "hose invite redeem"
+	acceptRole = "hose invite accept"
+)
+
+var errAuth = errors.New("invitation authentication failed")
+
+// message is sent by each side of a redemption.
+// The invited host sends a redeem message, and the inviting host answers with an accept message.
+type message struct {
+	ID        string `json:"id"`        // identifier of the invitation.
+	Identity  string `json:"identity"`  // identity of the sender.
+	MAC       []byte `json:"mac"`       // HMAC-SHA256 of the transcript, keyed with the invitation's secret.
+	Signature []byte `json:"signature"` // signature of the transcript by the sender.
+}
+
+// Serve waits for invited hosts to redeem pending invitations.
+// Each invited host is authenticated by the invitation's secret and saved in the known hosts file
+// as verified with the given options; the options' Trust and Authenticated fields are ignored.
+// Serve listens on the options' Port, or on the default handshake port if it is 0.
+// Invited hosts are served concurrently, and Serve returns once no invitations are pending,
+// because they were redeemed or because they expired.
+func Serve(opts handshake.Options) error {
+	keypair, err := key.LoadSigKeypair()
+	if err != nil {
+		return err
+	}
+
+	ln, err := hose_net.Listen(network, port(opts))
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	conns := make(chan net.Conn)
+	errs := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case conns <- conn:
+			case <-quit:
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait() // let redemptions in progress finish.
+	handled := make(chan struct{}, 1)
+	for {
+		invites, err := Load()
+		if err != nil {
+			return err
+		} else if len(invites) < 1 {
+			util.Logf("no pending invitations")
+			return nil
+		}
+		expiry := time.NewTimer(time.Until(firstExpiry(invites)))
+
+		select {
+		case conn := <-conns:
+			util.Logf("accepted connection from %s", conn.RemoteAddr())
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				if err := accept(conn, keypair, opts); err != nil {
+					util.Logf("%s: %v", conn.RemoteAddr(), err)
+				}
+				select {
+				case handled <- struct{}{}: // check whether invitations are still pending.
+				default:
+				}
+			}()
+		case <-handled:
+		case <-expiry.C:
+		case err := <-errs:
+			expiry.Stop()
+			return err
+		}
+		expiry.Stop()
+	}
+}
+
+// firstExpiry returns the time when the first of some invitations expires.
+func firstExpiry(invites []Invite) time.Time {
+	first := invites[0].Expires
+	for _, inv := range invites[1:] {
+		if inv.Expires.Before(first) {
+			first = inv.Expires
+		}
+	}
+	return first
+}
+
+// accept completes the redemption of an invitation by the host on the other end of a connection.
+func accept(conn net.Conn, keypair key.SigKeypair, opts handshake.Options) error {
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var redeem message
+	if err := json.NewDecoder(conn).Decode(&redeem); err != nil {
+		return err
+	}
+
+	// The invitation is single-use: it is deleted once the invited host is saved,
+	// and the lock keeps other processes from redeeming it in the meantime.
+	// If the host cannot be saved, e.g. because its keys changed, the invitation can be redeemed again.
+	var inv Invite
+	transcript := redeemTranscript(redeem.ID, redeem.Identity)
+	err := locked(func() error {
+		var err error
+		inv, err = lookup(redeem.ID)
+		if err != nil {
+			return err
+		}
+		rID, err := verify(redeem, transcript, inv.Secret)
+		if err != nil {
+			return err
+		}
+
+		// Save the invited host.
+		if raddr, err := remoteAddr(conn); err == nil {
+			rID.Addrs = append([]string{raddr.String()}, rID.Addrs...)
+		}
+		if inv.Name != "" {
+			opts.Name = inv.Name
+		} else if opts.Name == "" && rID.Name == "" && len(rID.Addrs) > 0 {
+			opts.Name = rID.Addrs[0]
+		}
+		opts.Trust, opts.Authenticated = true, true // by the secret.
+		if err := handshake.Import(rID, opts); err != nil {
+			return err
+		}
+		return remove(inv.ID())
+	})
+	if err != nil {
+		return err
+	}
+
+	// Send our identity to the invited host.
+	local, err := identity.Local(nil)
+	if err != nil {
+		return err
+	}
+	transcript = acceptTranscript(transcript, local.String())
+	reply, err := sign(inv.ID(), local, transcript, inv.Secret, keypair)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(conn).Encode(reply)
+}
+
+// Redeem redeems an invitation: it connects to the inviting host, which must be running Serve,
+// and they exchange identities. The inviting host is saved in the known hosts file with the given options
+// if its keys match the fingerprint in the token; the options' Expect and Trust fields are ignored.
+// The inviting host must listen on the options' Port, or on the default handshake port if it is 0.
+func Redeem(token Token, opts handshake.Options) error {
+	keypair, err := key.LoadSigKeypair()
+	if err != nil {
+		return err
+	}
+	local, err := identity.Local(nil)
+	if err != nil {
+		return err
+	}
+
+	conn, err := hose_net.Dial(context.Background(), network, token.Addrs, port(opts))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	raddr, err := hose_net.RemoteAddr(conn)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// Send our identity.
+	transcript := redeemTranscript(token.ID(), local.String())
+	redeem, err := sign(token.ID(), local, transcript, token.Secret, keypair)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(redeem); err != nil {
+		return err
+	}
+
+	// Receive the inviting host's identity.
+	var reply message
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return fmt.Errorf("invitation was not accepted: %v", err)
+	}
+	rID, err := verify(reply, acceptTranscript(transcript, reply.Identity), token.Secret)
+	if err != nil {
+		return err
+	}
+
+	// Save the inviting host, at the address that was reached and the others of the token.
+	addrs := []string{raddr.String()}
+	for _, addr := range append(slices.Clone(token.Addrs), rID.Addrs...) {
+		if !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	rID.Addrs = addrs
+	if opts.Name == "" && rID.Name == "" {
+		opts.Name = raddr.String()
+	}
+	opts.Expect = &token.Fingerprint
+	opts.Trust = true
+	return handshake.Import(rID, opts)
+}
+
+// port returns the TCP port to redeem invitations on.
+func port(opts handshake.Options) uint16 {
+	if opts.Port == 0 {
+		return handshake.Port
+	}
+	return opts.Port
+}
+
+// sign creates a message that authenticates an identity with an invitation's secret and the identity's signing key.
+func sign(id string, local identity.Identity, transcript, secret []byte, keypair key.SigKeypair) (message, error) {
+	sig, err := keypair.Sign(transcript)
+	if err != nil {
+		return message{}, err
+	}
+	return message{id, local.String(), mac(secret, transcript), sig}, nil
+}
+
+// verify checks that a message was sent by a host that knows the invitation's secret
+// and owns the signing key of the identity in the message, and returns that identity.
+func verify(msg message, transcript, secret []byte) (identity.Identity, error) {
+	if !hmac.Equal(msg.MAC, mac(secret, transcript)) {
+		return identity.Identity{}, errAuth
+	}
+	id, err := identity.Parse(msg.Identity)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+	if err := id.SigPublicKey.Verify(transcript, msg.Signature); err != nil {
+		return identity.Identity{}, errAuth
+	}
+	return id, nil
+}
+
+// redeemTranscript returns the data that a redeem message authenticates.
+func redeemTranscript(id, invitee string) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n", redeemRole, id, invitee))
+}
+
+// acceptTranscript returns the data that an accept message authenticates:
+// the redeem transcript followed by the identity of the inviting host.
+func acceptTranscript(redeemTranscript []byte, inviter string) []byte {
+	return append(append([]byte(nil), redeemTranscript...), fmt.Sprintf("%s\n%s\n", acceptRole, inviter)...)
+}
+
+func mac(secret, transcript []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(transcript)
+	return h.Sum(nil)
+}
+
+// remoteAddr returns the IP address of the remote end of a connection.
+func remoteAddr(conn net.Conn) (netip.Addr, error) {
+	return hose_net.RemoteAddr(conn)
+}
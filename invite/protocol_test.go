@@ -0,0 +1,148 @@
+package invite
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"git.samanthony.xyz/hose/handshake"
+	"git.samanthony.xyz/hose/hosts"
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/profile"
+)
+
+// Environment of the invited host, which runs in a child process because the selected profile is global.
+const (
+	tokenEnv   = "HOSE_TEST_INVITE_TOKEN"
+	dataDirEnv = "HOSE_TEST_INVITE_DATADIR"
+	portEnv    = "HOSE_TEST_INVITE_PORT"
+)
+
+// TestRedeem redeems an invitation and checks that both hosts saved each other as verified:
+// the invited host compared the inviting host's fingerprint with the token,
+// and the inviting host authenticated the invited host by the token's secret.
+func TestRedeem(t *testing.T) {
+	dir := t.TempDir()
+	profile.SetDataDir(dir)
+	if err := profile.Select("alice"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := Create([]string{"127.0.0.1"}, "bob", time.Minute)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	port := freePort(t)
+
+	served := make(chan error, 1)
+	go func() { served <- Serve(handshake.Options{Port: port}) }()
+	waitListening(t, port)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRedeemInvitee$")
+	cmd.Env = append(os.Environ(), tokenEnv+"="+token.String(), dataDirEnv+"="+dir, portEnv+"="+strconv.Itoa(int(port)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("invited host: %v\n%s", err, out)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Serve did not return once the invitation was redeemed")
+	}
+
+	checkTrust(t, "alice", "bob")
+	checkTrust(t, "bob", "alice")
+	if invites, err := Load(); err != nil || len(invites) != 0 {
+		t.Errorf("pending invitations after redeeming = %v, %v; want none", invites, err)
+	}
+}
+
+// TestServeExpired checks that Serve returns once the last pending invitation expires.
+func TestServeExpired(t *testing.T) {
+	profile.SetDataDir(t.TempDir())
+	if err := profile.Select("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create([]string{"127.0.0.1"}, "bob", 2*time.Second); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	port := freePort(t)
+
+	served := make(chan error, 1)
+	go func() { served <- Serve(handshake.Options{Port: port}) }()
+	waitListening(t, port)
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Serve did not return once the invitation expired")
+	}
+}
+
+// TestRedeemInvitee is the invited host of TestRedeem.
+func TestRedeemInvitee(t *testing.T) {
+	s, ok := os.LookupEnv(tokenEnv)
+	if !ok {
+		t.Skip("run by TestRedeem")
+	}
+	token, err := ParseToken(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(os.Getenv(portEnv), 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.SetDataDir(os.Getenv(dataDirEnv))
+	if err := profile.Select("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Redeem(token, handshake.Options{Name: "alice", Port: uint16(port)}); err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+}
+
+// checkTrust fails the test if a profile did not save a host as verified.
+func checkTrust(t *testing.T, self, name string) {
+	t.Helper()
+	if err := profile.Select(self); err != nil {
+		t.Fatal(err)
+	}
+	host, err := hosts.Lookup(name)
+	if err != nil {
+		t.Errorf("%s: %v", self, err)
+	} else if host.Trust != hosts.Verified {
+		t.Errorf("%s saved %s as %s; want %s", self, name, host.Trust, hosts.Verified)
+	}
+}
+
+// freePort returns a TCP port that nothing listens on.
+func freePort(t *testing.T) uint16 {
+	ln, err := net.Listen(network, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+// waitListening waits until something listens on a local port.
+// The probing connections are rejected as malformed redemptions.
+func waitListening(t *testing.T, port uint16) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial(network, hose_net.HostPort("127.0.0.1", port))
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("nothing listens on port %d", port)
+}
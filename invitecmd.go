@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"git.samanthony.xyz/hose/handshake"
+	"git.samanthony.xyz/hose/identity"
+	"git.samanthony.xyz/hose/invite"
+)
+
+const inviteUsage = "Usage: hose invite <create [-name <name>] [-addr <addr,...>] [-expires <duration>] | list | revoke <id> | serve [-replace] | redeem [-name <name>] [-alias <alias,...>] [-replace] <token>>"
+
+// inviteCommands are the subcommands of "hose invite".
+var inviteCommands = map[string]func(args []string) error{
+	"create": inviteCreate,
+	"list":   inviteList,
+	"revoke": inviteRevoke,
+	"serve":  inviteServe,
+	"redeem": inviteRedeem,
+}
+
+// inviteCmd manages invitations, which let two hosts exchange keys without being online at the same time.
+func inviteCmd(args []string) error {
+	if len(args) < 1 {
+		return errors.New(inviteUsage)
+	}
+	cmd, ok := inviteCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], inviteUsage)
+	}
+	return cmd(args[1:])
+}
+
+// inviteCreate creates an invitation and prints its token.
+func inviteCreate(args []string) error {
+	flags := flag.NewFlagSet("invite create", flag.ExitOnError)
+	name := flags.String("name", "", "name to save the invited host under (default: the name it announces)")
+	addrFlag := flags.String("addr", "", "comma-separated addresses that the invited host can reach this host at (default: the addresses of the network interfaces)")
+	expires := flags.Duration("expires", 7*24*time.Hour, "time until the invitation expires")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(inviteUsage)
+	}
+
+	addrs := splitList(*addrFlag)
+	if len(addrs) < 1 {
+		var err error
+		addrs, err = identity.LocalAddrs()
+		if err != nil {
+			return err
+		}
+	}
+	token, err := invite.Create(addrs, *name, *expires)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// inviteList prints the pending invitations.
+func inviteList(args []string) error {
+	if len(args) > 0 {
+		return errors.New(inviteUsage)
+	}
+	invites, err := invite.Load()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEXPIRES")
+	for _, inv := range invites {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", inv.ID(), orDash(inv.Name), formatTime(inv.Expires))
+	}
+	return w.Flush()
+}
+
+// inviteRevoke deletes a pending invitation.
+func inviteRevoke(args []string) error {
+	if len(args) != 1 {
+		return errors.New(inviteUsage)
+	}
+	return invite.Revoke(args[0])
+}
+
+// inviteServe waits for invited hosts to redeem pending invitations.
+func inviteServe(args []string) error {
+	flags := flag.NewFlagSet("invite serve", flag.ExitOnError)
+	replace := flags.Bool("replace", false, "accept changed keys of a known host")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(inviteUsage)
+	}
+	return invite.Serve(handshake.Options{Replace: *replace, Port: uint16(*handshakePort)})
+}
+
+// inviteRedeem redeems an invitation created by another host.
+func inviteRedeem(args []string) error {
+	flags := flag.NewFlagSet("invite redeem", flag.ExitOnError)
+	name := flags.String("name", "", "name to save the inviting host under (default: the name it announces)")
+	aliases := flags.String("alias", "", "comma-separated aliases of the inviting host")
+	replace := flags.Bool("replace", false, "accept changed keys of a known host")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New(inviteUsage)
+	}
+
+	token, err := invite.ParseToken(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return invite.Redeem(token, handshake.Options{
+		Name:    *name,
+		Aliases: splitList(*aliases),
+		Replace: *replace,
+		Port:    uint16(*handshakePort),
+	})
+}
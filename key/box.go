@@ -28,12 +28,12 @@ func LoadBoxKeypair() (BoxKeypair, error) {
 		return BoxKeypair{}, err
 	}
 
-	pub, err := loadBoxKey(boxPubKeyFile)
+	pub, err := loadBoxKey(boxPubKeyFile())
 	if err != nil {
 		return BoxKeypair{}, err
 	}
 
-	priv, err := loadBoxKey(boxPrivKeyFile)
+	priv, err := loadBoxKey(boxPrivKeyFile())
 	if err != nil {
 		return BoxKeypair{}, err
 	}
@@ -48,7 +48,7 @@ func LoadBoxPublicKey() (BoxPublicKey, error) {
 	if err != nil {
 		return BoxPublicKey{}, err
 	}
-	key, err := loadBoxKey(boxPubKeyFile)
+	key, err := loadBoxKey(boxPubKeyFile())
 	return BoxPublicKey(key), err
 }
 
@@ -3,29 +3,27 @@ package key
 import (
 	"errors"
 	"fmt"
-	"github.com/adrg/xdg"
 	"os"
 	"path/filepath"
 
+	"git.samanthony.xyz/hose/profile"
 	"git.samanthony.xyz/hose/util"
 )
 
 var (
-	dataDir = "hose"
-
-	// Encryption/decryption keypair for NaCl box operations.
-	boxPubKeyFile  = filepath.Join(xdg.DataHome, dataDir, "box_pub.key")
-	boxPrivKeyFile = filepath.Join(xdg.DataHome, dataDir, "box_priv.key")
-
-	// Sign/verify keypair for NaCl signing operations.
-	sigPubKeyFile  = filepath.Join(xdg.DataHome, dataDir, "sig_pub.key")
-	sigPrivKeyFile = filepath.Join(xdg.DataHome, dataDir, "sig_priv.key")
-
 	dirMode      os.FileMode = 0755
 	pubFileMode  os.FileMode = 0644
 	privFileMode os.FileMode = 0600
 )
 
+// Encryption/decryption keypair for NaCl box operations.
+func boxPubKeyFile() string  { return profile.Path("box_pub.key") }
+func boxPrivKeyFile() string { return profile.Path("box_priv.key") }
+
+// Sign/verify keypair for NaCl signing operations.
+func sigPubKeyFile() string  { return profile.Path("sig_pub.key") }
+func sigPrivKeyFile() string { return profile.Path("sig_priv.key") }
+
 // createFileIfNotExist creates a file with the specified permissions and returns it for writing.
 // It does not truncate an existing file. If the file already exists, an error is returned.
 func createFileIfNotExist(name string, mode os.FileMode) (*os.File, error) {
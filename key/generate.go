@@ -80,12 +80,12 @@ func generateKeypairIfNotExist(generate keyGenerator, pubFile, privFile string)
 // key file and the public box key in the public key file.  If either of the key files
 // already exist, they will not be overwritten; instead an error will be returned.
 func generateBoxKeypair() error {
-	return generateKeypair(boxKeyGenerator, boxPubKeyFile, boxPrivKeyFile)
+	return generateKeypair(boxKeyGenerator, boxPubKeyFile(), boxPrivKeyFile())
 }
 
 // generateBoxKeypairIfNotExist generates a NaCal box keypair if it doesn't already exist.
 func generateBoxKeypairIfNotExist() error {
-	return generateKeypairIfNotExist(boxKeyGenerator, boxPubKeyFile, boxPrivKeyFile)
+	return generateKeypairIfNotExist(boxKeyGenerator, boxPubKeyFile(), boxPrivKeyFile())
 }
 
 // NaCl box (encrypt/decrypt) keypair generator for use with generateKeypair().
@@ -105,12 +105,12 @@ func boxKeyGenerator(rand io.Reader) (publicKey, privateKey []byte, err error) {
 // instead an error will be returned.
 func generateSigKeypair() error {
 	util.Logf("generating new sign/verify keypair...")
-	return generateKeypair(sigKeyGenerator, sigPubKeyFile, sigPrivKeyFile)
+	return generateKeypair(sigKeyGenerator, sigPubKeyFile(), sigPrivKeyFile())
 }
 
 // generateSigKeypairIfNotExist generates a NaCl sign/verify keypair if it doesn't already exist.
 func generateSigKeypairIfNotExist() error {
-	return generateKeypairIfNotExist(sigKeyGenerator, sigPubKeyFile, sigPrivKeyFile)
+	return generateKeypairIfNotExist(sigKeyGenerator, sigPubKeyFile(), sigPrivKeyFile())
 }
 
 // NaCl sign/verify keypair generator for use with generateKeypair().
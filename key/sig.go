@@ -28,12 +28,12 @@ func LoadSigKeypair() (SigKeypair, error) {
 		return SigKeypair{}, err
 	}
 
-	pub, err := loadKey(sigPubKeyFile, DecodeSigPublicKey)
+	pub, err := loadKey(sigPubKeyFile(), DecodeSigPublicKey)
 	if err != nil {
 		return SigKeypair{}, err
 	}
 
-	priv, err := loadKey(sigPrivKeyFile, DecodeSigPrivateKey)
+	priv, err := loadKey(sigPrivKeyFile(), DecodeSigPrivateKey)
 	if err != nil {
 		return SigKeypair{}, err
 	}
@@ -49,7 +49,7 @@ func LoadSigPublicKey() (SigPublicKey, error) {
 	if err != nil {
 		return SigPublicKey{}, err
 	}
-	return loadKey(sigPubKeyFile, DecodeSigPublicKey)
+	return loadKey(sigPubKeyFile(), DecodeSigPublicKey)
 }
 
 // LoadSigPrivateKey reads the private signing key from disc,
@@ -60,7 +60,7 @@ func LoadSigPrivateKey() (SigPrivateKey, error) {
 	if err != nil {
 		return SigPrivateKey{}, err
 	}
-	return loadKey(sigPrivKeyFile, DecodeSigPrivateKey)
+	return loadKey(sigPrivKeyFile(), DecodeSigPrivateKey)
 }
 
 func (spk1 SigPublicKey) Compare(spk2 SigPublicKey) int {
@@ -98,6 +98,11 @@ func (pair SigKeypair) Sign(message []byte) ([]byte, error) {
 	return key.Sign(message)
 }
 
+// Public returns the public signature verification key of the keypair.
+func (pair SigKeypair) Public() SigPublicKey {
+	return pair.public
+}
+
 func (pair SigKeypair) GetPublicKey() saltpack.SigningPublicKey {
 	public := [ed25519.PublicKeySize]byte(pair.public)
 	return basic.NewSigningPublicKey(&public)
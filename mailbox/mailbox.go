@@ -0,0 +1,253 @@
+// Package mailbox stores signcrypted streams for hosts that are offline, and delivers them when the hosts fetch them.
+//
+// A sender deposits a stream in the mailbox of the receiver, which is identified by the fingerprint of its keys.
+// The stream is signcrypted end to end to the receiver's keys before it is deposited,
+// so the mailbox server only ever stores it encrypted, and can neither read nor modify it.
+// To fetch its messages, a host proves that it holds the keys with the fingerprint of the mailbox
+// by signing a challenge from the server. Messages are deleted once the host has received them.
+package mailbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/key"
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/util"
+)
+
+// Port is the default TCP port of a mailbox server.
+const Port = 60326
+
+const (
+	network = "tcp"
+	timeout = 1 * time.Minute
+
+	protocol = "HOSE-MAILBOX"
+	version  = "1"
+
+	putCmd   = "put"
+	fetchCmd = "fetch"
+
+	ok       = "OK"
+	msgTag   = "MSG"
+	endTag   = "END"
+	deleteOp = "DELETE"
+
+	// challengeContext is signed along with the server's challenge, so that the signature cannot be used for anything else.
+	challengeContext = "hose mailbox fetch v1\n"
+	challengeLen     = 32
+
+	maxLineLen = 512
+)
+
+// Message is a message in a mailbox.
+type Message struct {
+	ID   string
+	Time time.Time // when the message was deposited.
+	Size int64
+}
+
+// Deposit is a stream that is being deposited in a mailbox.
+type Deposit struct {
+	net.Conn
+	committed bool
+}
+
+// Put starts depositing a stream in the mailbox of the host with a fingerprint.
+// The mailbox address has the form "host" or "host:port".
+// The stream must be signcrypted to the host's keys. Commit must be called once it has been written.
+func Put(mailboxAddr string, to fingerprint.Fingerprint) (*Deposit, error) {
+	conn, err := dial(mailboxAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "%s %s %s %s\n", protocol, version, putCmd, to); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Deposit{Conn: conn}, nil
+}
+
+// Write writes to the stream. If the mailbox server stopped reading it, e.g. because the mailbox is full,
+// the error that the server replied with is returned.
+func (d *Deposit) Write(p []byte) (int, error) {
+	n, err := d.Conn.Write(p)
+	if err != nil {
+		d.SetReadDeadline(time.Now().Add(timeout))
+		if _, rerr := readReply(bufio.NewReader(d.Conn), d.Conn); rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, err
+}
+
+// Commit finishes depositing the stream, and waits until the mailbox server has stored it.
+func (d *Deposit) Commit() error {
+	if err := d.Conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return err
+	}
+	d.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := readReply(bufio.NewReader(d.Conn), d.Conn); err != nil {
+		return err
+	}
+	d.committed = true
+	return nil
+}
+
+// Close closes the connection to the mailbox server.
+// If the stream was not committed, the connection is reset so that the server discards the incomplete stream.
+func (d *Deposit) Close() error {
+	if !d.committed {
+		d.Conn.(*net.TCPConn).SetLinger(0)
+	}
+	return d.Conn.Close()
+}
+
+// Fetch receives the messages in the mailbox of the local host, which is identified by its keys.
+// Handle is called with each message in the order they were deposited; the message must not be read after it returns.
+// The messages for which handle returns nil are deleted from the mailbox, unless keep is true.
+// The others are left in the mailbox, and Fetch returns the errors that handle returned.
+func Fetch(mailboxAddr string, boxPubKey key.BoxPublicKey, sigKeypair key.SigKeypair, keep bool, handle func(Message, io.Reader) error) error {
+	conn, err := dial(mailboxAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// Prove that this host holds the keys of the mailbox.
+	sigPubKey := sigKeypair.Public()
+	if _, err := fmt.Fprintf(conn, "%s %s %s %x %x\n", protocol, version, fetchCmd, boxPubKey[:], sigPubKey[:]); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	challenge, err := readReply(r, conn)
+	if err != nil {
+		return err
+	}
+	nonce, err := hex.DecodeString(challenge)
+	if err != nil || len(nonce) != challengeLen {
+		return fmt.Errorf("mailbox %s: malformed challenge", conn.RemoteAddr())
+	}
+	sig, err := sigKeypair.Sign(append([]byte(challengeContext), nonce...))
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "%x\n", sig); err != nil {
+		return err
+	}
+
+	// Receive the messages.
+	var received []string
+	var errs []error
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		line, err := readReply(r, conn)
+		if err != nil {
+			return err
+		} else if line == endTag {
+			break
+		}
+		msg, err := parseMessage(line)
+		if err != nil {
+			return fmt.Errorf("mailbox %s: %v", conn.RemoteAddr(), err)
+		}
+		conn.SetReadDeadline(time.Time{})
+		body := io.LimitReader(r, msg.Size)
+		if err := handle(msg, body); err != nil {
+			errs = append(errs, fmt.Errorf("message %s: %v", msg.ID, err))
+		} else {
+			received = append(received, msg.ID)
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+	}
+	if len(received) == 0 && len(errs) == 0 {
+		util.Logf("no messages in mailbox %s", conn.RemoteAddr())
+	}
+
+	// Delete the messages that were received.
+	if !keep {
+		for _, id := range received {
+			if _, err := fmt.Fprintf(conn, "%s %s\n", deleteOp, id); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", endTag); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := readReply(r, conn); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// dial connects to a mailbox server, given as "host" or "host:port"; see hose_net.Dial.
+func dial(mailboxAddr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(hose_net.DefaultPort(mailboxAddr, Port))
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port in mailbox address %q", mailboxAddr)
+	}
+	util.Logf("connecting to mailbox %s", mailboxAddr)
+	return hose_net.Dial(context.Background(), network, []string{host}, uint16(port))
+}
+
+// readReply reads a line from the server. A line that starts with "error" is returned as an error.
+func readReply(r *bufio.Reader, conn net.Conn) (string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return "", fmt.Errorf("mailbox %s: %v", conn.RemoteAddr(), err)
+	} else if msg, ok := strings.CutPrefix(line, "error "); ok {
+		return "", fmt.Errorf("mailbox %s: %s", conn.RemoteAddr(), msg)
+	}
+	return line, nil
+}
+
+// parseMessage parses the line that precedes a message: "MSG <id> <unix time> <size>".
+func parseMessage(line string) (Message, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[0] != msgTag {
+		return Message{}, fmt.Errorf("malformed message header %q", line)
+	}
+	sec, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return Message{}, fmt.Errorf("malformed message time %q", fields[2])
+	}
+	size, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || size < 0 {
+		return Message{}, fmt.Errorf("malformed message size %q", fields[3])
+	}
+	return Message{fields[1], time.Unix(sec, 0), size}, nil
+}
+
+// readLine reads a line of at most maxLineLen bytes, without the newline.
+func readLine(r *bufio.Reader) (string, error) {
+	var line strings.Builder
+	for line.Len() <= maxLineLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		} else if b == '\n' {
+			return line.String(), nil
+		}
+		line.WriteByte(b)
+	}
+	return "", fmt.Errorf("line too long")
+}
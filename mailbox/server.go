@@ -0,0 +1,306 @@
+package mailbox
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/key"
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/util"
+)
+
+// Server is a mailbox server.
+type Server struct {
+	Dir         string // directory that the mailboxes are stored in; one subdirectory per host.
+	MaxSize     int64  // maximum size of a message in bytes.
+	MaxMessages int    // maximum number of messages in a mailbox; 0 for no limit.
+	MaxTotal    int64  // maximum total size of the messages in a mailbox in bytes; 0 for no limit.
+
+	mu sync.Mutex // held while checking the quota of a mailbox and delivering a message to it.
+}
+
+var (
+	// errTooLarge is returned when a message is larger than the server accepts.
+	errTooLarge = errors.New("message too large")
+
+	// errMailboxFull is returned when a message would exceed the quota of a mailbox.
+	errMailboxFull = errors.New("mailbox full")
+)
+
+// Serve runs the mailbox server on a port. It does not return unless listening fails.
+func (srv *Server) Serve(port uint16) error {
+	if err := os.MkdirAll(srv.Dir, 0700); err != nil {
+		return err
+	}
+	ln, err := hose_net.Listen(network, port)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := srv.handle(conn); err != nil {
+				util.Logf("%s: %v", conn.RemoteAddr(), err)
+				fmt.Fprintf(conn, "error %v\n", err)
+			}
+		}()
+	}
+}
+
+// handle serves one request.
+func (srv *Server) handle(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != protocol {
+		return fmt.Errorf("malformed request")
+	} else if fields[1] != version {
+		return fmt.Errorf("unsupported version %s", fields[1])
+	}
+	switch fields[2] {
+	case putCmd:
+		if len(fields) != 4 {
+			return fmt.Errorf("malformed request")
+		}
+		to, err := fingerprint.Parse(fields[3])
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Time{})
+		return srv.put(conn, r, to)
+	case fetchCmd:
+		if len(fields) != 5 {
+			return fmt.Errorf("malformed request")
+		}
+		fp, err := authenticate(conn, r, fields[3], fields[4])
+		if err != nil {
+			return err
+		}
+		return srv.fetch(conn, r, fp)
+	}
+	return fmt.Errorf("unknown command %s", fields[2])
+}
+
+// put stores a message in the mailbox of a host.
+// The message is written to a temporary file first, so that it is not delivered before it is complete.
+// It is rejected if it would exceed the quota of the mailbox.
+func (srv *Server) put(conn net.Conn, r io.Reader, to fingerprint.Fingerprint) error {
+	dir := srv.mailboxDir(to)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	left, err := srv.quota(dir)
+	if err != nil {
+		return err // full already; don't read the message.
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed.
+	defer f.Close()
+
+	limit := srv.MaxSize
+	if left >= 0 && left < limit {
+		limit = left
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	} else if n > srv.MaxSize {
+		return errTooLarge
+	} else if n > limit {
+		return errMailboxFull
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := srv.deliver(f.Name(), dir, n); err != nil {
+		return err
+	}
+	util.Logf("stored %d bytes from %s for %s", n, conn.RemoteAddr(), to)
+	_, err = fmt.Fprintf(conn, "%s\n", ok)
+	return err
+}
+
+// deliver moves a complete message into a mailbox if it fits in the quota.
+// Other messages may have been delivered to the mailbox while this one was being received, so the quota is checked again.
+func (srv *Server) deliver(name, dir string, size int64) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	left, err := srv.quota(dir)
+	if err != nil {
+		return err
+	} else if left >= 0 && size > left {
+		return errMailboxFull
+	}
+	return os.Rename(name, filepath.Join(dir, newID()))
+}
+
+// quota returns the number of bytes that are left in the quota of a mailbox, or -1 if its size is not limited.
+// It returns errMailboxFull if the mailbox cannot take any more messages.
+func (srv *Server) quota(dir string) (int64, error) {
+	if srv.MaxMessages <= 0 && srv.MaxTotal <= 0 {
+		return -1, nil
+	}
+	msgs, err := list(dir)
+	if err != nil {
+		return 0, err
+	}
+	if srv.MaxMessages > 0 && len(msgs) >= srv.MaxMessages {
+		return 0, errMailboxFull
+	} else if srv.MaxTotal <= 0 {
+		return -1, nil
+	}
+	left := srv.MaxTotal
+	for _, msg := range msgs {
+		left -= msg.Size
+	}
+	if left <= 0 {
+		return 0, errMailboxFull
+	}
+	return left, nil
+}
+
+// authenticate challenges a host to prove that it holds the keys that it claims, and returns their fingerprint.
+func authenticate(conn net.Conn, r *bufio.Reader, boxHex, sigHex string) (fingerprint.Fingerprint, error) {
+	var boxPubKey key.BoxPublicKey
+	var sigPubKey key.SigPublicKey
+	if b, err := hex.DecodeString(boxHex); err != nil || len(b) != len(boxPubKey) {
+		return fingerprint.Fingerprint{}, fmt.Errorf("malformed encryption key")
+	} else {
+		copy(boxPubKey[:], b)
+	}
+	if b, err := hex.DecodeString(sigHex); err != nil || len(b) != len(sigPubKey) {
+		return fingerprint.Fingerprint{}, fmt.Errorf("malformed signature verification key")
+	} else {
+		copy(sigPubKey[:], b)
+	}
+
+	nonce := make([]byte, challengeLen)
+	rand.Read(nonce)
+	if _, err := fmt.Fprintf(conn, "%x\n", nonce); err != nil {
+		return fingerprint.Fingerprint{}, err
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return fingerprint.Fingerprint{}, err
+	}
+	sig, err := hex.DecodeString(line)
+	if err != nil {
+		return fingerprint.Fingerprint{}, fmt.Errorf("malformed signature")
+	}
+	if err := sigPubKey.Verify(append([]byte(challengeContext), nonce...), sig); err != nil {
+		return fingerprint.Fingerprint{}, fmt.Errorf("bad signature")
+	}
+	return fingerprint.Of(boxPubKey, sigPubKey), nil
+}
+
+// fetch sends the messages in the mailbox of a host, and deletes the ones that the host asks to delete.
+func (srv *Server) fetch(conn net.Conn, r *bufio.Reader, fp fingerprint.Fingerprint) error {
+	dir := srv.mailboxDir(fp)
+	msgs, err := list(dir)
+	if err != nil {
+		return err
+	}
+	util.Logf("delivering %d messages to %s at %s", len(msgs), fp, conn.RemoteAddr())
+	w := bufio.NewWriter(conn)
+	for _, msg := range msgs {
+		if err := sendMessage(w, dir, msg); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "%s\n", endTag)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// Delete the messages that the host received. Only messages that were sent can be deleted.
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		} else if line == endTag {
+			break
+		}
+		id, ok := strings.CutPrefix(line, deleteOp+" ")
+		if !ok || !slices.ContainsFunc(msgs, func(msg Message) bool { return msg.ID == id }) {
+			return fmt.Errorf("cannot delete %q", id)
+		}
+		if err := os.Remove(filepath.Join(dir, id)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(conn, "%s\n", ok)
+	return err
+}
+
+// sendMessage sends a message, preceded by its header.
+func sendMessage(w io.Writer, dir string, msg Message) error {
+	f, err := os.Open(filepath.Join(dir, msg.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(w, "%s %s %d %d\n", msgTag, msg.ID, msg.Time.Unix(), msg.Size); err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, f, msg.Size)
+	return err
+}
+
+// list returns the messages in a mailbox in the order they were deposited.
+func list(dir string) ([]Message, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var msgs []Message
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || !entry.Type().IsRegular() {
+			continue // incomplete.
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, Message{entry.Name(), info.ModTime(), info.Size()})
+	}
+	return msgs, nil // ReadDir sorts by name, and IDs sort by time.
+}
+
+// mailboxDir returns the directory of the mailbox of a host.
+func (srv *Server) mailboxDir(fp fingerprint.Fingerprint) string {
+	return filepath.Join(srv.Dir, hex.EncodeToString(fp[:]))
+}
+
+// newID returns a new message ID. IDs sort in the order they were created.
+func newID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return fmt.Sprintf("%020d-%x", time.Now().UnixNano(), buf)
+}
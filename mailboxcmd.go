@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/keybase/saltpack"
+	"github.com/tonistiigi/units"
+	"io"
+	"os"
+
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/inbox"
+	"git.samanthony.xyz/hose/key"
+	"git.samanthony.xyz/hose/mailbox"
+	"git.samanthony.xyz/hose/profile"
+	"git.samanthony.xyz/hose/util"
+)
+
+const (
+	mailboxUsage = "Usage: hose mailbox [-port <port>] [-dir <dir>] [-max-size <bytes>] [-max-messages <n>] [-max-total <bytes>]"
+	fetchUsage   = "Usage: hose fetch [-keep] [-inbox] <mailbox>"
+)
+
+// mailboxCmd runs a mailbox server that stores signcrypted streams for hosts that are offline.
+func mailboxCmd(args []string) error {
+	flags := flag.NewFlagSet("mailbox", flag.ExitOnError)
+	port := flags.Uint("port", mailbox.Port, "TCP port to listen on")
+	dir := flags.String("dir", profile.Path("mailbox"), "directory to store the messages in")
+	maxSize := flags.Int64("max-size", 1<<30, "maximum size of a message in bytes")
+	maxMessages := flags.Int("max-messages", 1000, "maximum number of messages in a mailbox; 0 for no limit")
+	maxTotal := flags.Int64("max-total", 4<<30, "maximum total size of the messages in a mailbox in bytes; 0 for no limit")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(mailboxUsage)
+	} else if *port < 1 || *port > 65535 {
+		return fmt.Errorf("invalid port %d", *port)
+	}
+	srv := &mailbox.Server{Dir: *dir, MaxSize: *maxSize, MaxMessages: *maxMessages, MaxTotal: *maxTotal}
+	return srv.Serve(uint16(*port))
+}
+
+// fetch receives the messages that were left in the local host's mailbox and writes them to stdout,
+// or saves them in the inbox. Messages from unknown senders are left in the mailbox.
+func fetch(args []string) error {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	keep := flags.Bool("keep", false, "leave the messages in the mailbox after fetching them")
+	toInbox := flags.Bool("inbox", false, "save the messages in the inbox instead of writing them to stdout")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New(fetchUsage)
+	}
+
+	keyring, err := loadKeyring()
+	if err != nil {
+		return err
+	}
+	boxPubKey, err := key.LoadBoxPublicKey()
+	if err != nil {
+		return err
+	}
+	sigKeypair, err := key.LoadSigKeypair()
+	if err != nil {
+		return err
+	}
+
+	return mailbox.Fetch(flags.Arg(0), boxPubKey, sigKeypair, *keep, func(msg mailbox.Message, r io.Reader) error {
+		if *toInbox {
+			return spoolMessage(msg, r, keyring)
+		}
+		return openMessage(msg, r, keyring)
+	})
+}
+
+// openMessage decrypts and verifies a message from a mailbox and writes it to stdout.
+func openMessage(msg mailbox.Message, r io.Reader, keyring *key.Keyring) error {
+	_, plaintext, err := openMessageStream(msg, r, keyring)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(os.Stdout, plaintext)
+	util.Logf("received %#.2f", units.Bytes(n)*units.B)
+	return err
+}
+
+// spoolMessage decrypts and verifies a message from a mailbox and saves it in the inbox.
+func spoolMessage(msg mailbox.Message, r io.Reader, keyring *key.Keyring) error {
+	host, plaintext, err := openMessageStream(msg, r, keyring)
+	if err != nil {
+		return err
+	}
+	w, err := inbox.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, plaintext); err != nil {
+		w.Discard()
+		return err
+	}
+	item, err := w.Commit(inbox.Item{From: host.Name, Fingerprint: host.Fingerprint(), Received: msg.Time})
+	if err != nil {
+		return err
+	}
+	util.Logf("received %#.2f as item %d", units.Bytes(item.Size)*units.B, item.ID)
+	return nil
+}
+
+// openMessageStream opens the signcrypted stream of a message from a mailbox, and identifies its sender.
+func openMessageStream(msg mailbox.Message, r io.Reader, keyring *key.Keyring) (hosts.Host, io.Reader, error) {
+	senderPub, plaintext, err := saltpack.NewSigncryptOpenStream(r, keyring, nil)
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+	sigPubKey, err := senderKey(senderPub)
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+	host, err := hosts.LookupSigPublicKey(sigPubKey)
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+	util.Logf("message from %s, left at %s", host.Name, formatTime(msg.Time))
+	return host, plaintext, nil
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/keybase/saltpack"
@@ -10,30 +13,92 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 
+	"git.samanthony.xyz/hose/discovery"
+	"git.samanthony.xyz/hose/fingerprint"
 	"git.samanthony.xyz/hose/handshake"
 	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/identity"
 	"git.samanthony.xyz/hose/key"
+	"git.samanthony.xyz/hose/mailbox"
 	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/profile"
+	"git.samanthony.xyz/hose/relay"
 	"git.samanthony.xyz/hose/util"
 )
 
 const (
-	port    = 60321
-	network = "tcp"
-	usage   = "Usage: hose <-handshake <rhost> | -r | -s <rhost>>"
+	defaultPort = 60321
+	network     = "tcp"
+	usage       = "Usage: hose [-identity <name>] [-datadir <dir>] [-port <port>] [-handshake-port <port>] [-bind <addr> | -interface <name>] <-handshake <rhost> [-name <name>] [-alias <alias,...>] [-replace] [-fingerprint <format>] [-relay <host[:port]> -channel <channel>] | -r [-tofu] [-advertise=false] [-relay <host[:port]> | -connect <rhost[:port]> | -spool] | -s <rhost[:port]> [-tofu] [-relay <host[:port]>] [-mailbox <host[:port]>] [-queue <duration>] [-listen] | handshake -import <identity> ... | hosts ... | whoami [-qr] | config [host] | export-identity ... | import-identity ... | introduce ... | import-introduction ... | invite ... | sync ... | peers ... | relay [-port <port>] | mailbox ... | fetch ... | inbox ... | queue ...>"
 )
 
 var (
+	profileName   = flag.String("identity", profile.Default, "name of the identity (profile) to use")
+	dataDir       = flag.String("datadir", "", "override the data directory")
 	handshakeHost = flag.String("handshake", "", "exchange public keys with remote host")
+	hostName      = flag.String("name", "", "name to save the remote host under during a handshake (default: the name it announces)")
+	hostAliases   = flag.String("alias", "", "comma-separated aliases of the remote host during a handshake")
+	replaceKeys   = flag.Bool("replace", false, "accept changed keys of a known host during a handshake")
 	recvFlag      = flag.Bool("r", false, "receive")
 	sendHost      = flag.String("s", "", "send to remote host")
+	tofu          = flag.Bool("tofu", false, "trust the keys of unknown hosts on first use while sending or receiving")
+	advertise     = flag.Bool("advertise", true, "advertise this host on the local network while receiving")
+	listen        = flag.Bool("listen", false, "while sending, wait for the receiver to connect instead of connecting to it")
+	connectHost   = flag.String("connect", "", "while receiving, connect to the remote host instead of waiting for it to connect")
+	spool         = flag.Bool("spool", false, "while receiving, keep receiving transfers into the inbox instead of writing one to stdout")
+	queueFor      = flag.Duration("queue", 0, "while sending, queue the data if the receiver cannot be reached, and keep retrying for this long, e.g. 24h")
+	mailboxAddr   = flag.String("mailbox", "", "mailbox server (host[:port]) to leave the data in if the receiver cannot be reached while sending")
+	relayAddr     = flag.String("relay", "", "relay server (host[:port]) to use when hosts cannot connect directly")
+	channel       = flag.String("channel", "", "relay channel that both hosts use during a handshake through a relay")
+	listenPort    = flag.Uint("port", defaultPort, "TCP port to receive transfers on")
+	handshakePort = flag.Uint("handshake-port", handshake.Port, "TCP port to exchange keys on during a handshake")
+	bindAddr      = flag.String("bind", "", "local address to listen on (default: every address)")
+	bindInterface = flag.String("interface", "", "network interface to listen on, e.g. eth0 (default: every interface)")
+	timeout       = flag.Duration("timeout", hose_net.DialTimeout, "how long to try to connect to a remote host")
+
+	fingerprintFormat fingerprint.Format
 )
 
+func init() {
+	flag.Var(&fingerprintFormat, "fingerprint", "fingerprint format during a handshake: hex, words, emoji or art")
+}
+
 func main() {
 	flag.Parse()
-	if *handshakeHost != "" {
-		if err := handshake.Handshake(*handshakeHost); err != nil {
+	if err := loadConfig(); err != nil {
+		util.Eprintf("%v\n", err)
+	}
+	if *dataDir != "" {
+		profile.SetDataDir(*dataDir)
+	}
+	if err := profile.Select(*profileName); err != nil {
+		util.Eprintf("%v\n", err)
+	}
+	if err := checkPort(*listenPort); err != nil {
+		util.Eprintf("-port: %v\n", err)
+	} else if err := checkPort(*handshakePort); err != nil {
+		util.Eprintf("-handshake-port: %v\n", err)
+	}
+	applySettings()
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			util.Eprintf("%v\n", err)
+		}
+	} else if *handshakeHost != "" {
+		if err := handshake.Handshake(*handshakeHost, handshake.Options{
+			Name:        *hostName,
+			Aliases:     splitList(*hostAliases),
+			Replace:     *replaceKeys,
+			Fingerprint: fingerprintFormat,
+			Relay:       *relayAddr,
+			Channel:     *channel,
+			Port:        uint16(*handshakePort),
+		}); err != nil {
 			util.Eprintf("%v\n", err)
 		}
 	} else if *recvFlag {
@@ -51,28 +116,87 @@ func main() {
 	}
 }
 
+// splitList splits a comma-separated list. The empty string is an empty list.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// checkPort returns a non-nil error if a port number is out of range.
+func checkPort(port uint) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	return nil
+}
+
+// applySettings passes the settings that other packages keep to them.
+func applySettings() {
+	hose_net.SetListenAddr(*bindAddr)
+	hose_net.SetInterface(*bindInterface)
+	hose_net.SetDialTimeout(*timeout)
+}
+
+// splitTarget splits a remote host given as "host:port" into the host and the port.
+// The port is 0 if none is given; a bare IPv6 address has no port.
+func splitTarget(target string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, 0, nil // no port.
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("invalid port in %q", target)
+	}
+	return host, uint16(port), nil
+}
+
+// remotePort returns the port to connect to a remote host on:
+// the port given with the host by the user, else the host's port in the known hosts file, else the default.
+func remotePort(host hosts.Host, port uint16) uint16 {
+	if port != 0 {
+		return port
+	} else if host.Port != 0 {
+		return host.Port
+	}
+	return defaultPort
+}
+
 // recv pipes data from the remote host to stdout.
+// The sender is authenticated by its signature key, regardless of the address it connects from.
 func recv() error {
-	// Load private decryption key.
-	keyring := key.NewKeyring()
-	if err := loadBoxKeypair(keyring); err != nil {
+	keyring, err := loadKeyring()
+	if err != nil {
 		return err
 	}
+	if *spool {
+		return spoolTransfers()
+	}
 
-	// Accept connection from remote host.
-	conn, err := hose_net.AcceptConnection(network, port)
-	defer conn.Close()
-	util.Logf("accepted connection from %s", conn.RemoteAddr())
-
-	// Load remote host's signature verification key.
-	host, err := lookupHost(conn)
+	// Accept connection from remote host, or connect to it if it is listening.
+	var conn net.Conn
+	if *connectHost != "" {
+		conn, err = dialSender(*connectHost)
+	} else {
+		// Let senders find this host on the local network.
+		if *advertise {
+			if adv, err := advertiseSelf(); err != nil {
+				util.Logf("not advertising on the local network: %v", err)
+			} else {
+				defer adv.Close()
+			}
+		}
+		conn, err = accept()
+	}
 	if err != nil {
 		return err
 	}
-	keyring.ImportSigPublicKey(host.SigPublicKey)
+	defer conn.Close()
+	util.Logf("connected to %s", conn.RemoteAddr())
 
-	// Decrypt and verify stream.
-	_, plaintext, err := saltpack.NewSigncryptOpenStream(conn, keyring, nil)
+	host, plaintext, err := openTransfer(conn, keyring)
 	if err != nil {
 		return err
 	}
@@ -80,7 +204,69 @@ func recv() error {
 	// Read data.
 	n, err := io.Copy(os.Stdout, plaintext)
 	util.Logf("received %#.2f", units.Bytes(n)*units.B)
-	return err
+	if err != nil {
+		return err
+	}
+	return hosts.Seen(host.Name)
+}
+
+// openTransfer reads the announcement of a sender, if any, and opens the signcrypted stream that follows it.
+// It returns the sender and the decrypted data, which is verified as it is read.
+func openTransfer(conn net.Conn, keyring *key.Keyring) (hosts.Host, io.Reader, error) {
+	// Read the sender's announcement, if any.
+	r := bufio.NewReader(conn)
+	a, announced, err := identity.ReadAnnouncement(r)
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+	if announced && *tofu {
+		keyring.ImportSigPublicKey(a.SigPublicKey)
+		if a.Reply {
+			if err := announce(conn, false); err != nil {
+				return hosts.Host{}, nil, err
+			}
+		}
+	} else if announced && a.Reply {
+		util.Logf("%s asked for the keys of this host; use -tofu to announce them", conn.RemoteAddr())
+	}
+
+	// Decrypt and verify stream.
+	senderPub, plaintext, err := saltpack.NewSigncryptOpenStream(r, keyring, nil)
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+
+	// Identify the sender.
+	sigPubKey, err := senderKey(senderPub)
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+	host, err := hosts.LookupSigPublicKey(sigPubKey)
+	if errors.Is(err, hosts.ErrNoSuchHost) && announced && *tofu {
+		host, err = trustSender(a, conn, sigPubKey)
+	}
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+	util.Logf("receiving from %s", host.Name)
+	warnIfNewAddr(host, conn)
+	return host, plaintext, nil
+}
+
+// loadKeyring loads the local decryption keys and the signature verification keys of the known hosts.
+func loadKeyring() (*key.Keyring, error) {
+	keyring := key.NewKeyring()
+
+	// Load private decryption key.
+	if err := loadBoxKeypair(keyring); err != nil {
+		return nil, err
+	}
+
+	// Load signature verification keys of known hosts.
+	if err := loadSigPublicKeys(keyring); err != nil {
+		return nil, err
+	}
+	return keyring, nil
 }
 
 // loadBoxKeypair reads the local encryption/decryption keypair from disc and imports it into the keyring.
@@ -93,21 +279,71 @@ func loadBoxKeypair(keyring *key.Keyring) error {
 	return nil
 }
 
-// lookupHost searches for a remote host in the known hosts file.
-func lookupHost(conn net.Conn) (hosts.Host, error) {
-	rhost, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+// loadSigPublicKeys imports the signature verification keys of all known hosts into the keyring.
+func loadSigPublicKeys(keyring *key.Keyring) error {
+	knownHosts, err := hosts.Load()
+	if err != nil {
+		return err
+	}
+	for _, host := range knownHosts {
+		keyring.ImportSigPublicKey(host.SigPublicKey)
+	}
+	return nil
+}
+
+// senderKey returns the signature verification key of the sender of a stream.
+func senderKey(senderPub saltpack.SigningPublicKey) (key.SigPublicKey, error) {
+	if senderPub == nil {
+		return key.SigPublicKey{}, fmt.Errorf("refusing stream from anonymous sender")
+	}
+	var sigPubKey key.SigPublicKey
+	kid := senderPub.ToKID()
+	if len(kid) != len(sigPubKey) {
+		return key.SigPublicKey{}, fmt.Errorf("malformed sender key")
+	}
+	copy(sigPubKey[:], kid)
+	return sigPubKey, nil
+}
+
+// accept accepts a connection from a sender, either directly or through the relay server, if any.
+// Senders reach this host on the relay through the channel named after its fingerprint.
+func accept() (net.Conn, error) {
+	if *relayAddr == "" {
+		return hose_net.AcceptConnection(network, uint16(*listenPort))
+	}
+	id, err := identity.Local(nil)
 	if err != nil {
-		return hosts.Host{}, err
+		return nil, err
 	}
-	raddr, err := netip.ParseAddr(rhost)
+	ln, err := hose_net.Listen(network, uint16(*listenPort))
 	if err != nil {
-		return hosts.Host{}, err
+		return nil, err
 	}
-	return hosts.Lookup(raddr)
+	return relay.Accept(ln, *relayAddr, fingerprint.Of(id.BoxPublicKey, id.SigPublicKey).String())
+}
+
+// warnIfNewAddr warns the user if a known host connects from an address that is not in the known hosts file.
+// Connections through a relay server are not checked, since their address is the relay's.
+func warnIfNewAddr(host hosts.Host, conn net.Conn) {
+	if relay.Relayed(conn) {
+		return
+	}
+	raddr, err := remoteAddr(conn)
+	if err != nil {
+		return
+	}
+	if !host.HasAddr(raddr) {
+		util.Logf("warning: host %q connected from new address %s", host.Name, raddr)
+	}
+}
+
+// remoteAddr returns the IP address of the remote end of a connection.
+func remoteAddr(conn net.Conn) (netip.Addr, error) {
+	return hose_net.RemoteAddr(conn)
 }
 
-// send pipes data from stdin to the remote host.
-func send(rHostName string) error {
+// send pipes data from stdin to the remote host, given as "host" or "host:port".
+func send(target string) error {
 	var keyCreator basic.EphemeralKeyCreator
 
 	// Load sender signing keypair.
@@ -124,25 +360,68 @@ func send(rHostName string) error {
 	}
 
 	// Load receiver encryption key.
-	util.Logf("loading encryption key for %s", rHostName)
-	rAddr, err := resolve(rHostName)
+	rHostName, rPort, err := splitTarget(target)
 	if err != nil {
 		return err
 	}
-	rHost, err := hosts.Lookup(rAddr)
-	if err != nil {
+	util.Logf("loading encryption key for %s", rHostName)
+	rHost, rAddrs, err := findHost(rHostName)
+	unknown := errors.Is(err, hosts.ErrNoSuchHost) && len(rAddrs) > 0
+	hc, restore, cfgErr := applyHostConfig(rHostName, rHost)
+	if cfgErr != nil {
+		return cfgErr
+	}
+	defer restore()
+	if err != nil && !(unknown && *tofu) {
 		return err
 	}
+	if len(hc.addrs) > 0 && !unknown {
+		rAddrs = hc.addrs
+	}
+	if rPort == 0 {
+		rPort = hc.port
+	}
 
-	// Connect to remote host.
-	rAddrPort := netip.AddrPortFrom(rAddr, port)
-	util.Logf("connecting to %s", rAddrPort)
-	conn, err := net.Dial(network, rAddrPort.String())
+	// Connect to remote host, or wait for it to connect.
+	var conn net.Conn
+	if *listen {
+		if unknown {
+			return fmt.Errorf("cannot trust an unknown receiver on first use while listening; connect to it instead")
+		}
+		conn, err = hose_net.AcceptConnection(network, uint16(*listenPort))
+	} else {
+		conn, err = dialReceiver(rHost, rAddrs, remotePort(rHost, rPort), unknown)
+	}
+	// Leave the data in the receiver's mailbox if it cannot be reached.
+	var deposit *mailbox.Deposit
+	if err != nil && !unknown && *mailboxAddr != "" {
+		util.Logf("%v", err)
+		deposit, err = mailbox.Put(*mailboxAddr, rHost.Fingerprint())
+		conn = deposit
+	}
+	// Queue the data to retry later if it cannot be delivered now.
+	if err != nil && !unknown && !*listen && *queueFor > 0 {
+		util.Logf("%v", err)
+		return queueSend(rHost, rHostName, rPort, sigKeypair)
+	}
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
+	// Trust on first use: announce this host, and learn the keys of an unknown receiver.
+	if *tofu && deposit == nil {
+		if err := announce(conn, unknown); err != nil {
+			return err
+		}
+	}
+	if unknown {
+		rHost, err = learnReceiver(conn, rHostName)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Create signcrypted stream.
 	util.Logf("signcrypting stream")
 	rcvrBoxKeys := []saltpack.BoxPublicKey{rHost.BoxPublicKey}
@@ -151,28 +430,142 @@ func send(rHostName string) error {
 	if err != nil {
 		return err
 	}
-	defer plaintext.Close()
+	if deposit == nil {
+		defer plaintext.Close()
+	}
 
 	// Send data.
 	n, err := io.Copy(plaintext, os.Stdin)
 	util.Logf("sent %#.2f", units.Bytes(n)*units.B)
-	return err
+	if err != nil {
+		return err
+	}
+	if deposit != nil {
+		// The stream must be complete before the mailbox stores it.
+		if err := plaintext.Close(); err != nil {
+			return err
+		}
+		if err := deposit.Commit(); err != nil {
+			return err
+		}
+		util.Logf("left the data in the mailbox of %s", rHost.Name)
+		return nil
+	}
+	return hosts.Seen(rHost.Name)
+}
+
+// dialReceiver connects to a receiver on a port at one of its addresses.
+// If it cannot be reached there and it is a known host, it is looked for on the local network,
+// and then through the relay server, if any.
+func dialReceiver(host hosts.Host, addrs []string, port uint16, unknown bool) (net.Conn, error) {
+	conn, err := dial(addrs, port)
+	if err == nil || unknown {
+		return conn, err
+	}
+	// Its address may have changed; look for it on the local network.
+	util.Logf("%v", err)
+	conn, err = dialDiscovered(host, port)
+	if err != nil && *relayAddr != "" {
+		// It may not be reachable directly; go through the relay server.
+		util.Logf("%v", err)
+		conn, err = relay.Dial(*relayAddr, host.Fingerprint().String())
+	}
+	return conn, err
+}
+
+// dialSender connects to a sender that is waiting for the receiver to connect, i.e. "hose -s <rhost> -listen".
+// The sender may be unknown, in which case it must be given by address. It is given as "host" or "host:port".
+func dialSender(target string) (net.Conn, error) {
+	name, port, err := splitTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	host, addrs, err := findHost(name)
+	if err != nil && !(errors.Is(err, hosts.ErrNoSuchHost) && len(addrs) > 0) {
+		return nil, err
+	}
+	hc, restore, err := applyHostConfig(name, host)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	if len(hc.addrs) > 0 {
+		addrs = hc.addrs
+	}
+	if port == 0 {
+		port = hc.port
+	}
+	return dial(addrs, remotePort(host, port))
 }
 
-// resolve resolves the address of a host.
-// Host can either be the name of a host, or an IP address.
-func resolve(host string) (netip.Addr, error) {
-	if addr, err := netip.ParseAddr(host); err == nil {
-		// Host is an IP address.
-		return addr, nil
+// findHost searches the known hosts file for the host that the user refers to by name or address.
+// It also returns the addresses to try when connecting to the host.
+func findHost(name string) (hosts.Host, []string, error) {
+	host, err := hosts.Lookup(name)
+	if err == nil {
+		addrs := slices.DeleteFunc(slices.Clone(host.Addrs), hosts.IsHashed)
+		if host.HasAddrString(name) {
+			return host, []string{name}, nil
+		} else if len(addrs) < 1 && len(host.Addrs) > 0 {
+			return host, []string{name}, nil // addresses are hashed; the name may resolve.
+		} else if len(addrs) < 1 {
+			return hosts.Host{}, nil, fmt.Errorf("no known address for host %s", host.Name)
+		}
+		return host, addrs, nil
+	} else if !errors.Is(err, hosts.ErrNoSuchHost) {
+		return hosts.Host{}, nil, err
+	}
+
+	// Not known by that name; look up its addresses instead,
+	// and use the ones that belong to a known host.
+	addrs, err := hose_net.Resolve(context.Background(), name)
+	if err != nil {
+		return hosts.Host{}, nil, err
+	}
+	for _, addr := range addrs {
+		host, err := hosts.LookupAddr(addr)
+		if errors.Is(err, hosts.ErrNoSuchHost) {
+			continue
+		} else if err != nil {
+			return hosts.Host{}, nil, err
+		}
+		var known []string
+		for _, addr := range addrs {
+			if host.HasAddr(addr) {
+				known = append(known, addr.String())
+			}
+		}
+		return host, known, nil
 	}
+	all := make([]string, len(addrs))
+	for i, addr := range addrs {
+		all[i] = addr.String()
+	}
+	return hosts.Host{}, all, fmt.Errorf("%w: %s", hosts.ErrNoSuchHost, name)
+}
+
+// dial connects to a port at the first reachable address of a remote host.
+// The addresses are raced against each other; see hose_net.Dial.
+func dial(addrs []string, port uint16) (net.Conn, error) {
+	return hose_net.Dial(context.Background(), network, addrs, port)
+}
+
+// advertiseSelf advertises the name and fingerprint of the local host on the local network.
+func advertiseSelf() (*discovery.Advertiser, error) {
+	id, err := identity.Local(nil)
+	if err != nil {
+		return nil, err
+	}
+	return discovery.Advertise(id.Name, fingerprint.Of(id.BoxPublicKey, id.SigPublicKey))
+}
 
-	// Host is a hostname; resolve its address.
-	addrs, err := net.LookupHost(host)
+// dialDiscovered looks for a known host on the local network by its fingerprint and connects to it on a port.
+func dialDiscovered(host hosts.Host, port uint16) (net.Conn, error) {
+	util.Logf("looking for %s on the local network", host.Name)
+	peer, err := discovery.Find(host.Fingerprint(), discovery.Timeout)
 	if err != nil {
-		return netip.Addr{}, err
-	} else if len(addrs) < 1 {
-		return netip.Addr{}, fmt.Errorf("no such host %s", host)
+		return nil, err
 	}
-	return netip.ParseAddr(addrs[0])
+	util.Logf("found %s at %s", host.Name, peer.Addr)
+	return dial([]string{peer.Addr.String()}, port)
 }
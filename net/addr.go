@@ -0,0 +1,61 @@
+package net
+
+import (
+	"fmt"
+	std_net "net"
+	"net/netip"
+	"strings"
+)
+
+// ParseAddr parses an IP address. IPv6 addresses may have a zone, e.g. "fe80::1%eth0",
+// and may be enclosed in brackets, as they are in URLs.
+// IPv4-mapped IPv6 addresses, e.g. "::ffff:10.0.0.34", are converted to IPv4 addresses,
+// so that they compare equal to the IPv4 addresses they represent.
+func ParseAddr(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(trimBrackets(s))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// SameAddr reports whether two IP addresses refer to the same host.
+// A link-local address without a zone matches the same address in any zone.
+func SameAddr(a, b netip.Addr) bool {
+	a, b = a.Unmap(), b.Unmap()
+	if a.Zone() == "" || b.Zone() == "" {
+		return a.WithZone("") == b.WithZone("")
+	}
+	return a == b
+}
+
+// RemoteAddr returns the IP address of the remote end of a connection, including its zone, if any.
+func RemoteAddr(conn std_net.Conn) (netip.Addr, error) {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addrPort.Addr().Unmap(), nil
+}
+
+// HostPort joins a host and a port into an address to dial.
+// The host is a hostname or an IP address, which may be in brackets and may have a zone.
+func HostPort(host string, port uint16) string {
+	return std_net.JoinHostPort(trimBrackets(host), fmt.Sprintf("%d", port))
+}
+
+// DefaultPort adds a port to an address of the form "host" or "host:port" if it does not have one.
+func DefaultPort(addr string, port uint16) string {
+	if _, _, err := std_net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return HostPort(addr, port)
+}
+
+// trimBrackets removes the brackets around an IPv6 address, e.g. "[fe80::1%eth0]".
+func trimBrackets(s string) string {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,168 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	std_net "net"
+	"net/netip"
+	"time"
+
+	"git.samanthony.xyz/hose/util"
+)
+
+const (
+	// DialTimeout is how long Dial tries to connect before giving up by default.
+	DialTimeout = 10 * time.Second
+
+	// attemptDelay is how long Dial waits for a connection attempt before starting the next one
+	// alongside it, as recommended by RFC 8305.
+	attemptDelay = 250 * time.Millisecond
+)
+
+// dialTimeout is how long Dial tries to connect before giving up.
+var dialTimeout = DialTimeout
+
+// SetDialTimeout changes how long Dial tries to connect before giving up.
+func SetDialTimeout(timeout time.Duration) {
+	dialTimeout = timeout
+}
+
+// Dial connects to a port of the first of several addresses that accepts the connection.
+// Addresses are IP addresses or hostnames; hostnames are resolved to all of their IPv4 and IPv6 addresses.
+//
+// The attempts are raced as in RFC 8305 ("Happy Eyeballs"): the addresses are tried in order,
+// alternating between IPv6 and IPv4, and each attempt starts when the previous one fails
+// or has not succeeded after a short delay. The first connection wins, and the other attempts are cancelled.
+func Dial(ctx context.Context, network string, addrs []string, port uint16) (std_net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	ips, errs := resolveAll(ctx, addrs)
+	if len(ips) < 1 {
+		if len(errs) < 1 {
+			return nil, errors.New("no address to connect to")
+		}
+		return nil, errors.Join(errs...)
+	}
+	ips = interleave(ips)
+
+	type result struct {
+		conn  std_net.Conn
+		raddr string
+		err   error
+	}
+	results := make(chan result, len(ips))
+	var dialer std_net.Dialer
+	next, pending := 0, 0
+	start := func() {
+		raddr := netip.AddrPortFrom(ips[next], port).String()
+		next++
+		pending++
+		util.Logf("connecting to %s", raddr)
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, raddr)
+			results <- result{conn, raddr, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(attemptDelay)
+	defer timer.Stop()
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				// Close the connections of attempts that succeed in the meantime.
+				go func(n int) {
+					for range n {
+						if res := <-results; res.err == nil {
+							res.conn.Close()
+						}
+					}
+				}(pending)
+				util.Logf("connected to %s", res.raddr)
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			if next < len(ips) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Resolve returns the IP addresses of a host, which is either a hostname or an IP address (see ParseAddr).
+// IPv4-mapped IPv6 addresses are converted to IPv4 addresses.
+func Resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := ParseAddr(host); err == nil {
+		// Host is an IP address.
+		return []netip.Addr{addr}, nil
+	}
+
+	// Host is a hostname; resolve its addresses.
+	addrs, err := std_net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	} else if len(addrs) < 1 {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, nil
+}
+
+// resolveAll resolves a list of hosts, and returns their addresses without duplicates.
+// It also returns the errors of the hosts that could not be resolved.
+func resolveAll(ctx context.Context, hosts []string) ([]netip.Addr, []error) {
+	var ips []netip.Addr
+	var errs []error
+	seen := make(map[netip.Addr]bool)
+	for _, host := range hosts {
+		addrs, err := Resolve(ctx, host)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, addr := range addrs {
+			if !seen[addr] {
+				seen[addr] = true
+				ips = append(ips, addr)
+			}
+		}
+	}
+	return ips, errs
+}
+
+// interleave reorders addresses so that IPv6 and IPv4 addresses alternate, starting with the family of the first one.
+// The order of the addresses of each family is kept.
+func interleave(addrs []netip.Addr) []netip.Addr {
+	var first, second []netip.Addr
+	for _, addr := range addrs {
+		if addr.Is6() == addrs[0].Is6() {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+	out := make([]netip.Addr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
@@ -1,19 +1,162 @@
 package net
 
 import (
+	"errors"
 	"fmt"
 	std_net "net"
+	"net/netip"
+	"sync"
 
 	"git.samanthony.xyz/hose/util"
 )
 
+var (
+	// listenAddr is the local address to listen on; if it is empty, every address is listened on.
+	listenAddr string
+
+	// listenInterface is the name of the network interface whose addresses are listened on, if any.
+	listenInterface string
+)
+
+// SetListenAddr makes Listen listen on a local IP address or hostname instead of every address.
+func SetListenAddr(addr string) {
+	listenAddr = trimBrackets(addr)
+}
+
+// SetInterface makes Listen listen only on the addresses of a network interface, e.g. "eth0".
+func SetInterface(name string) {
+	listenInterface = name
+}
+
+// AcceptConnection listens on a port and returns the first connection.
 func AcceptConnection(network string, port uint16) (std_net.Conn, error) {
-	laddr := std_net.JoinHostPort("", fmt.Sprintf("%d", port))
-	ln, err := std_net.Listen(network, laddr)
+	ln, err := Listen(network, port)
 	if err != nil {
 		return nil, err
 	}
 	defer ln.Close()
-	util.Logf("listening on %s", laddr)
 	return ln.Accept()
 }
+
+// Listen listens on a port.
+// By default, it listens on every interface, over both IPv4 and IPv6 where the system supports it;
+// SetListenAddr and SetInterface restrict it to an address or to the addresses of an interface.
+func Listen(network string, port uint16) (std_net.Listener, error) {
+	hosts, err := listenHosts()
+	if err != nil {
+		return nil, err
+	}
+	var lns []std_net.Listener
+	for _, host := range hosts {
+		laddr := std_net.JoinHostPort(host, fmt.Sprintf("%d", port))
+		ln, err := std_net.Listen(network, laddr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		util.Logf("listening on %s", laddr)
+		lns = append(lns, ln)
+	}
+	if len(lns) == 1 {
+		return lns[0], nil
+	}
+	return newMultiListener(lns), nil
+}
+
+// listenHosts returns the local hosts to listen on. The empty string means every address.
+func listenHosts() ([]string, error) {
+	if listenInterface == "" {
+		return []string{listenAddr}, nil
+	} else if listenAddr != "" {
+		return nil, errors.New("cannot listen on both an address and an interface")
+	}
+	ifi, err := std_net.InterfaceByName(listenInterface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr()
+		if ip.Is6() && ip.IsLinkLocalUnicast() {
+			ip = ip.WithZone(ifi.Name)
+		}
+		hosts = append(hosts, ip.String())
+	}
+	if len(hosts) < 1 {
+		return nil, fmt.Errorf("interface %s has no addresses", ifi.Name)
+	}
+	return hosts, nil
+}
+
+// multiListener accepts connections from several listeners.
+type multiListener struct {
+	lns   []std_net.Listener
+	conns chan acceptResult
+	once  sync.Once
+	done  chan struct{}
+}
+
+type acceptResult struct {
+	conn std_net.Conn
+	err  error
+}
+
+func newMultiListener(lns []std_net.Listener) *multiListener {
+	ml := &multiListener{lns: lns, conns: make(chan acceptResult), done: make(chan struct{})}
+	for _, ln := range lns {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				select {
+				case ml.conns <- acceptResult{conn, err}:
+				case <-ml.done:
+					if conn != nil {
+						conn.Close()
+					}
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	return ml
+}
+
+// Accept returns the next connection that any of the listeners accepts.
+func (ml *multiListener) Accept() (std_net.Conn, error) {
+	select {
+	case res := <-ml.conns:
+		return res.conn, res.err
+	case <-ml.done:
+		return nil, std_net.ErrClosed
+	}
+}
+
+// Close closes all of the listeners.
+func (ml *multiListener) Close() error {
+	var errs []error
+	ml.once.Do(func() {
+		close(ml.done)
+		for _, ln := range ml.lns {
+			errs = append(errs, ln.Close())
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Addr returns the address of the first listener.
+func (ml *multiListener) Addr() std_net.Addr {
+	return ml.lns[0].Addr()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"git.samanthony.xyz/hose/discovery"
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/hosts"
+)
+
+const peersUsage = "Usage: hose peers [-timeout <duration>] [-fingerprint <format>]"
+
+// peers lists the hosts that advertise themselves on the local network,
+// and the names of the ones that are known hosts.
+func peers(args []string) error {
+	flags := flag.NewFlagSet("peers", flag.ExitOnError)
+	timeout := flags.Duration("timeout", discovery.Timeout, "time to wait for hosts to answer")
+	var format fingerprint.Format
+	flags.Var(&format, "fingerprint", "fingerprint format: hex, words or emoji")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(peersUsage)
+	} else if format.Multiline() {
+		return fmt.Errorf("cannot list fingerprints in %s format", format)
+	}
+
+	knownHosts, err := hosts.Load()
+	if err != nil {
+		return err
+	}
+	found, err := discovery.Browse(*timeout)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tFINGERPRINT\tKNOWN AS")
+	for _, peer := range found {
+		knownAs := "-"
+		i := slices.IndexFunc(knownHosts, func(host hosts.Host) bool { return host.Fingerprint() == peer.Fingerprint })
+		if i >= 0 {
+			knownAs = knownHosts[i].Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", orDash(peer.Name), peer.Addr, peer.Fingerprint.Format(format), knownAs)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,68 @@
+package profile
+
+import (
+	"fmt"
+	"github.com/adrg/xdg"
+	"path/filepath"
+	"strings"
+)
+
+// Default is the name of the profile that is used if none is selected.
+const Default = "default"
+
+// profilesDir is the subdirectory of the data directory that contains the non-default profiles.
+const profilesDir = "profiles"
+
+var (
+	// dataDir is the base directory that contains all of hose's data.
+	dataDir = filepath.Join(xdg.DataHome, "hose")
+
+	// name is the name of the selected profile.
+	name = Default
+)
+
+// SetDataDir overrides the base data directory.
+func SetDataDir(dir string) {
+	dataDir = dir
+}
+
+// Select selects the named profile.
+// Each profile has its own keypairs and known hosts.
+func Select(profile string) error {
+	if err := validate(profile); err != nil {
+		return err
+	}
+	name = profile
+	return nil
+}
+
+// Name returns the name of the selected profile.
+func Name() string {
+	return name
+}
+
+// Dir returns the directory that contains the selected profile's keys and known hosts.
+// The default profile lives directly in the data directory so that
+// keys generated before profiles existed continue to be used.
+func Dir() string {
+	if name == Default {
+		return dataDir
+	}
+	return filepath.Join(dataDir, profilesDir, name)
+}
+
+// Path returns the path of a file in the selected profile's directory.
+func Path(file string) string {
+	return filepath.Join(Dir(), file)
+}
+
+// validate returns a non-nil error if a profile name is not safe to use as a directory name.
+func validate(profile string) error {
+	if profile == "" {
+		return fmt.Errorf("empty profile name")
+	}
+	if profile == "." || profile == ".." || strings.ContainsAny(profile, `/\`) {
+		return fmt.Errorf("invalid profile name %q", profile)
+	}
+	return nil
+}
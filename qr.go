@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"rsc.io/qr"
+	"strings"
+)
+
+// quietZone is the width of the light border around a QR code, in modules.
+const quietZone = 2
+
+// printQR renders text as a QR code in the terminal.
+// Each character cell holds two rows of modules using Unicode half blocks.
+// Light modules are drawn as blocks, so the code scans on terminals with a dark background.
+func printQR(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return err
+	}
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true // quiet zone.
+		}
+		return !code.Black(x, y)
+	}
+
+	var b strings.Builder
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	_, err = fmt.Fprint(w, b.String())
+	return err
+}
@@ -0,0 +1,277 @@
+// Package queue keeps transfers that could not be delivered, so that they can be retried later.
+//
+// The data of a transfer is signcrypted to the receiver's keys before it is queued,
+// so it is never stored in plaintext. Each item is stored in the queue directory of the selected profile
+// as two files: "<id>.data" holds the signcrypted stream, and "<id>.json" describes where it goes
+// and how delivering it went so far. Failed deliveries are retried with exponential backoff until the deadline of the item.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/profile"
+)
+
+const (
+	dataExt   = ".data"
+	metaExt   = ".json"
+	tmpPrefix = ".tmp-"
+
+	// MinBackoff is the time to wait before retrying a delivery for the first time.
+	MinBackoff = 30 * time.Second
+	// MaxBackoff is the longest time to wait between attempts.
+	MaxBackoff = 1 * time.Hour
+)
+
+// ErrNoSuchItem is returned when an item is not in the queue.
+var ErrNoSuchItem = errors.New("no such item")
+
+// Item describes a transfer in the queue.
+type Item struct {
+	ID          int
+	To          string                  // name or address that the user gave for the receiver; never hashed.
+	Fingerprint fingerprint.Fingerprint // fingerprint of the keys that the data is signcrypted to.
+	Port        uint16                  // port to deliver to; 0 to use the receiver's port.
+	Created     time.Time
+	Deadline    time.Time // when to give up.
+	Attempts    int       // number of failed deliveries.
+	NextAttempt time.Time
+	LastError   string
+	Size        int64
+}
+
+// jsonItem is the encoding of an item's description.
+type jsonItem struct {
+	To          string    `json:"to"`
+	Fingerprint string    `json:"fingerprint"`
+	Port        uint16    `json:"port,omitempty"`
+	Created     time.Time `json:"created"`
+	Deadline    time.Time `json:"deadline"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	Size        int64     `json:"size"`
+}
+
+// Expired reports whether the deadline of an item has passed.
+func (item Item) Expired(now time.Time) bool {
+	return now.After(item.Deadline)
+}
+
+// Failed records a failed delivery, and schedules the next attempt.
+func (item *Item) Failed(err error, now time.Time) {
+	item.Attempts++
+	item.LastError = err.Error()
+	item.NextAttempt = now.Add(Backoff(item.Attempts))
+}
+
+// Backoff returns the time to wait after a number of failed deliveries:
+// MinBackoff after the first, doubling after each one, up to MaxBackoff.
+func Backoff(attempts int) time.Duration {
+	d := MinBackoff
+	for i := 1; i < attempts && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, MaxBackoff)
+}
+
+// Writer writes the signcrypted data of a new item. The item is added to the queue by Commit.
+type Writer struct {
+	f    *os.File
+	item Item
+}
+
+// dir returns the queue directory of the selected profile.
+func dir() string {
+	return profile.Path("queue")
+}
+
+// Create starts a new item for a receiver, which is given up on after the deadline.
+// Name is the name or address that the user gave for the receiver, by which it is looked up again to deliver the item;
+// the receiver's name in the known hosts file may be hashed.
+// The item is delivered to port, or to the receiver's port if it is 0.
+// The caller must call Commit once the data is written, or Discard if it is not wanted.
+func Create(to hosts.Host, name string, port uint16, deadline time.Time) (*Writer, error) {
+	if err := os.MkdirAll(dir(), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir(), tmpPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	item := Item{To: name, Fingerprint: to.Fingerprint(), Port: port, Created: now, Deadline: deadline, NextAttempt: now.Add(MinBackoff)}
+	return &Writer{f, item}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+// Discard deletes the data that was written.
+func (w *Writer) Discard() error {
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+// Commit adds the item to the queue under a new ID and returns it.
+func (w *Writer) Commit() (Item, error) {
+	defer os.Remove(w.f.Name()) // no-op once linked.
+	defer w.f.Close()
+	if err := w.f.Sync(); err != nil {
+		return Item{}, err
+	}
+	info, err := w.f.Stat()
+	if err != nil {
+		return Item{}, err
+	}
+	item := w.item
+	item.Size = info.Size()
+
+	// Claim the next free ID. Linking fails if the ID was claimed by another process in the meantime.
+	items, err := List()
+	if err != nil {
+		return Item{}, err
+	}
+	item.ID = 1
+	if len(items) > 0 {
+		item.ID = items[len(items)-1].ID + 1
+	}
+	for {
+		err := os.Link(w.f.Name(), path(item.ID, dataExt))
+		if err == nil {
+			break
+		} else if !errors.Is(err, os.ErrExist) {
+			return Item{}, err
+		}
+		item.ID++
+	}
+	if err := store(item); err != nil {
+		os.Remove(path(item.ID, dataExt))
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// List returns the items in the queue in the order they were queued.
+func List() ([]Item, error) {
+	entries, err := os.ReadDir(dir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), metaExt)
+		if !ok || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			continue // not an item.
+		}
+		item, err := Lookup(id)
+		if errors.Is(err, ErrNoSuchItem) {
+			continue // dropped in the meantime.
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b Item) int { return a.ID - b.ID })
+	return items, nil
+}
+
+// Lookup returns the description of an item.
+func Lookup(id int) (Item, error) {
+	data, err := os.ReadFile(path(id, metaExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return Item{}, fmt.Errorf("%w: %d", ErrNoSuchItem, id)
+	} else if err != nil {
+		return Item{}, err
+	}
+	var ji jsonItem
+	if err := json.Unmarshal(data, &ji); err != nil {
+		return Item{}, fmt.Errorf("queued item %d: %v", id, err)
+	}
+	fp, err := fingerprint.Parse(ji.Fingerprint)
+	if err != nil {
+		return Item{}, fmt.Errorf("queued item %d: %v", id, err)
+	}
+	return Item{id, ji.To, fp, ji.Port, ji.Created, ji.Deadline, ji.Attempts, ji.NextAttempt, ji.LastError, ji.Size}, nil
+}
+
+// Open opens the signcrypted data of an item for reading.
+func Open(id int) (io.ReadCloser, error) {
+	if _, err := Lookup(id); err != nil {
+		return nil, err
+	}
+	return os.Open(path(id, dataExt))
+}
+
+// Update saves the description of an item, e.g. after a failed delivery.
+// It does nothing if the item was dropped in the meantime.
+func Update(item Item) error {
+	if _, err := os.Stat(path(item.ID, dataExt)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return store(item)
+}
+
+// Delete removes an item from the queue.
+func Delete(id int) error {
+	if err := os.Remove(path(id, metaExt)); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %d", ErrNoSuchItem, id)
+	} else if err != nil {
+		return err
+	}
+	return os.Remove(path(id, dataExt))
+}
+
+// store writes the description of an item.
+func store(item Item) error {
+	data, err := json.MarshalIndent(jsonItem{
+		item.To, item.Fingerprint.String(), item.Port, item.Created, item.Deadline,
+		item.Attempts, item.NextAttempt, item.LastError, item.Size,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(path(item.ID, metaExt), append(data, '\n'))
+}
+
+// path returns the path of a file of an item.
+func path(id int, ext string) string {
+	return filepath.Join(dir(), strconv.Itoa(id)+ext)
+}
+
+// writeFile writes a file by renaming a temporary file, so that it is never seen partially written.
+func writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockFile returns the path of the file that the process that delivers the queue holds a lock on.
+func lockFile() string {
+	return filepath.Join(dir(), "runner.lock")
+}
+
+// LogFile returns the path of the file that a background process that delivers the queue logs to.
+func LogFile() string {
+	return filepath.Join(dir(), "runner.log")
+}
+
+// Claim claims the queue for the calling process, so that only one process delivers it at a time.
+// It returns false if another process already has. Release must be called to give up the claim.
+func Claim() (release func(), ok bool, err error) {
+	if err := os.MkdirAll(dir(), 0700); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(lockFile(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false, err
+	}
+	ok, err = tryLock(f)
+	if err != nil || !ok {
+		f.Close()
+		return nil, false, err
+	}
+	return func() { f.Close() }, true, nil
+}
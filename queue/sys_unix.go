@@ -0,0 +1,24 @@
+//go:build unix
+
+package queue
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock acquires an exclusive lock on a file without waiting. It returns false if the file is already locked.
+// The lock is released when the file is closed.
+func tryLock(f *os.File) (bool, error) {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return true, nil
+		} else if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		} else if err != syscall.EINTR {
+			return false, err
+		}
+	}
+}
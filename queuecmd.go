@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+	"github.com/tonistiigi/units"
+	"io"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+	"time"
+
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/key"
+	"git.samanthony.xyz/hose/profile"
+	"git.samanthony.xyz/hose/queue"
+	"git.samanthony.xyz/hose/util"
+)
+
+const queueUsage = "Usage: hose queue <list | retry [id...] | drop <id...> | run>"
+
+// queueCommands are the subcommands of "hose queue".
+var queueCommands = map[string]func(args []string) error{
+	"list":  queueList,
+	"retry": queueRetry,
+	"drop":  queueDrop,
+	"run":   queueRun,
+}
+
+// queueCmd manages the transfers that were queued with "hose -s <rhost> -queue <duration>".
+// Without a subcommand, it lists them.
+func queueCmd(args []string) error {
+	if len(args) < 1 {
+		return queueList(nil)
+	}
+	cmd, ok := queueCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], queueUsage)
+	}
+	return cmd(args[1:])
+}
+
+// queueSend signcrypts stdin to a receiver that cannot be reached, queues it,
+// and starts a background process that delivers the queue, unless one is already running.
+// RHostName is the name or address that the user gave for the receiver.
+// The data is delivered to port, or to the receiver's port if it is 0.
+func queueSend(rHost hosts.Host, rHostName string, port uint16, sigKeypair key.SigKeypair) error {
+	var keyCreator basic.EphemeralKeyCreator
+	sessionKey, err := key.NewReceiverSymmetricKey()
+	if err != nil {
+		return err
+	}
+	w, err := queue.Create(rHost, rHostName, port, time.Now().Add(*queueFor))
+	if err != nil {
+		return err
+	}
+	util.Logf("signcrypting stream")
+	rcvrBoxKeys := []saltpack.BoxPublicKey{rHost.BoxPublicKey}
+	rcvrSymmetricKeys := []saltpack.ReceiverSymmetricKey{sessionKey}
+	plaintext, err := saltpack.NewSigncryptSealStream(w, keyCreator, sigKeypair, rcvrBoxKeys, rcvrSymmetricKeys)
+	if err != nil {
+		w.Discard()
+		return err
+	}
+	if _, err := io.Copy(plaintext, os.Stdin); err != nil {
+		w.Discard()
+		return err
+	}
+	if err := plaintext.Close(); err != nil {
+		w.Discard()
+		return err
+	}
+	item, err := w.Commit()
+	if err != nil {
+		return err
+	}
+	util.Logf("queued %#.2f for %s as item %d; retrying until %s", units.Bytes(item.Size)*units.B, rHostName, item.ID, formatTime(item.Deadline))
+	return startQueueRunner()
+}
+
+// startQueueRunner starts "hose queue run" in the background, unless a process is already delivering the queue.
+// The background process logs to queue.LogFile.
+func startQueueRunner() error {
+	release, ok, err := queue.Claim()
+	if err != nil {
+		return err
+	} else if !ok {
+		return nil // already running.
+	}
+	release()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"-identity", profile.Name()}
+	if *dataDir != "" {
+		args = append(args, "-datadir", *dataDir)
+	}
+	// Settings from the configuration file and the environment are loaded by the process itself.
+	if settingSources["relay"] == sourceFlag {
+		args = append(args, "-relay", *relayAddr)
+	}
+	args = append(args, "queue", "run")
+	log, err := os.OpenFile(queue.LogFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	util.Logf("delivering the queue in the background (pid %d); see %s", cmd.Process.Pid, queue.LogFile())
+	return cmd.Process.Release()
+}
+
+// queueList prints a summary of every queued transfer.
+func queueList(args []string) error {
+	if len(args) > 0 {
+		return errors.New(queueUsage)
+	}
+	items, err := queue.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTO\tSIZE\tQUEUED\tDEADLINE\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+	for _, item := range items {
+		fmt.Fprintf(w, "%d\t%s\t%#.2f\t%s\t%s\t%d\t%s\t%s\n",
+			item.ID, item.To, units.Bytes(item.Size)*units.B, formatTime(item.Created), formatTime(item.Deadline),
+			item.Attempts, formatTime(item.NextAttempt), orDash(item.LastError))
+	}
+	return w.Flush()
+}
+
+// queueRetry retries delivering queued transfers now; all of them if no IDs are given.
+// If a background process is delivering the queue, it is left to retry them.
+func queueRetry(args []string) error {
+	items, err := queueItems(args)
+	if err != nil {
+		return err
+	}
+	release, ok, err := queue.Claim()
+	if err != nil {
+		return err
+	} else if !ok {
+		for _, item := range items {
+			item.NextAttempt = time.Now()
+			if err := queue.Update(item); err != nil {
+				return err
+			}
+		}
+		util.Logf("the queue is being delivered by another process; it will retry shortly")
+		return nil
+	}
+	defer release()
+
+	var errs []error
+	for _, item := range items {
+		if err := attempt(item); err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %v", item.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// queueDrop removes transfers from the queue without delivering them.
+func queueDrop(args []string) error {
+	if len(args) < 1 {
+		return errors.New(queueUsage)
+	}
+	for _, arg := range args {
+		id, err := parseItemID(arg)
+		if err != nil {
+			return err
+		}
+		if err := queue.Delete(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueRun delivers the queue until it is empty. Each transfer is retried with exponential backoff until its deadline.
+// Only one process delivers the queue at a time.
+func queueRun(args []string) error {
+	flags := flag.NewFlagSet("queue run", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(queueUsage)
+	}
+	release, ok, err := queue.Claim()
+	if err != nil {
+		return err
+	} else if !ok {
+		return errors.New("the queue is already being delivered by another process")
+	}
+	defer release()
+
+	for {
+		items, err := queue.List()
+		if err != nil {
+			return err
+		} else if len(items) == 0 {
+			util.Logf("queue is empty")
+			return nil
+		}
+
+		// Check again soon, to notice transfers that are queued or retried in the meantime.
+		now := time.Now()
+		next := now.Add(queue.MinBackoff)
+		for _, item := range items {
+			if item.Expired(now) {
+				util.Logf("giving up on item %d for %s: %s", item.ID, item.To, orDash(item.LastError))
+				if err := queue.Delete(item.ID); err != nil && !errors.Is(err, queue.ErrNoSuchItem) {
+					return err
+				}
+				continue
+			}
+			if !item.NextAttempt.After(now) {
+				if err := attempt(item); err == nil {
+					continue
+				}
+				item, err = queue.Lookup(item.ID)
+				if errors.Is(err, queue.ErrNoSuchItem) {
+					continue
+				} else if err != nil {
+					return err
+				}
+			}
+			next = minTime(next, minTime(item.NextAttempt, item.Deadline.Add(time.Second)))
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+// attempt tries to deliver a queued transfer. It is removed from the queue if it is delivered,
+// and the failure is recorded otherwise.
+func attempt(item queue.Item) error {
+	util.Logf("delivering item %d to %s (attempt %d)", item.ID, item.To, item.Attempts+1)
+	rHost, err := deliver(item)
+	if err != nil {
+		util.Logf("item %d: %v", item.ID, err)
+		item.Failed(err, time.Now())
+		if updateErr := queue.Update(item); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+	util.Logf("delivered %#.2f to %s", units.Bytes(item.Size)*units.B, item.To)
+	if err := queue.Delete(item.ID); err != nil && !errors.Is(err, queue.ErrNoSuchItem) {
+		return err
+	}
+	return hosts.Seen(rHost.Name)
+}
+
+// deliver sends the signcrypted data of a queued transfer to its receiver.
+// The receiver must still have the keys that the data was signcrypted to.
+// It returns the receiver.
+func deliver(item queue.Item) (hosts.Host, error) {
+	rHost, rAddrs, err := findHost(item.To)
+	if err != nil {
+		return hosts.Host{}, err
+	} else if rHost.Fingerprint() != item.Fingerprint {
+		return hosts.Host{}, fmt.Errorf("the keys of %s changed after the data was queued", item.To)
+	}
+	hc, restore, err := applyHostConfig(item.To, rHost)
+	if err != nil {
+		return hosts.Host{}, err
+	}
+	defer restore()
+	if len(hc.addrs) > 0 {
+		rAddrs = hc.addrs
+	}
+	port := item.Port
+	if port == 0 {
+		port = hc.port
+	}
+	r, err := queue.Open(item.ID)
+	if err != nil {
+		return hosts.Host{}, err
+	}
+	defer r.Close()
+	conn, err := dialReceiver(rHost, rAddrs, remotePort(rHost, port), false)
+	if err != nil {
+		return hosts.Host{}, err
+	}
+	defer conn.Close()
+	_, err = io.Copy(conn, r)
+	return rHost, err
+}
+
+// queueItems returns the queued transfers with the given IDs, or all of them if there are none.
+func queueItems(ids []string) ([]queue.Item, error) {
+	if len(ids) == 0 {
+		return queue.List()
+	}
+	var items []queue.Item
+	for _, s := range ids {
+		id, err := parseItemID(s)
+		if err != nil {
+			return nil, err
+		}
+		item, err := queue.Lookup(id)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// minTime returns the earlier of two times.
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
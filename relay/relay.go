@@ -0,0 +1,190 @@
+// Package relay forwards connections between hosts that cannot reach each other directly,
+// e.g. because they are behind NATs or on different network segments.
+//
+// A host that waits for a connection registers with the relay server as a listener on a channel,
+// and a host that wants to connect to it registers as a connector on the same channel.
+// The server pairs them and forwards the bytes between them without looking at them;
+// the data is end-to-end encrypted and signed by the hosts, so the relay cannot read or modify it.
+// Transfers use the fingerprint of the receiver's keys as the channel,
+// and handshakes use a channel that both users agree on.
+package relay
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/util"
+)
+
+// Port is the default TCP port of a relay server.
+const Port = 60325
+
+const (
+	network = "tcp"
+	timeout = 1 * time.Minute
+
+	protocol = "HOSE-RELAY"
+	version  = "1"
+
+	listenRole  = "listen"
+	connectRole = "connect"
+
+	ok = "OK"
+
+	maxLineLen    = 512
+	maxChannelLen = 128
+)
+
+// id identifies this process to the relay server,
+// so that the server does not pair a listener with a connector of the same process, e.g. during a handshake.
+var id = newID()
+
+func newID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Conn is a connection to a remote host through a relay server.
+// Its remote address is the address of the relay server.
+type Conn struct {
+	*net.TCPConn
+	r *bufio.Reader
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// WriteTo implements io.WriterTo, so that io.Copy does not bypass the buffered reader.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	return c.r.WriteTo(w)
+}
+
+// Relayed reports whether a connection goes through a relay server.
+func Relayed(conn net.Conn) bool {
+	_, ok := conn.(*Conn)
+	return ok
+}
+
+// Dial connects to the host that listens on a channel of a relay server.
+// The relay address has the form "host" or "host:port".
+func Dial(relayAddr, channel string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return register(ctx, relayAddr, connectRole, channel)
+}
+
+// Listen waits for a host to connect through a channel of a relay server.
+// It returns when a host connects or the context is done.
+func Listen(ctx context.Context, relayAddr, channel string) (net.Conn, error) {
+	return register(ctx, relayAddr, listenRole, channel)
+}
+
+// Accept waits for a connection either on a listener or through a channel of a relay server,
+// and returns the first one. The listener is closed before Accept returns.
+func Accept(ln net.Listener, relayAddr, channel string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	go func() {
+		conn, err := ln.Accept()
+		results <- result{conn, err}
+	}()
+	go func() {
+		conn, err := Listen(ctx, relayAddr, channel)
+		results <- result{conn, err}
+	}()
+
+	var errs []error
+	for i := range 2 {
+		res := <-results
+		if res.err == nil {
+			ln.Close()
+			cancel()
+			if i == 0 {
+				// Close the other connection, in case it was established in the meantime.
+				go func() {
+					if res := <-results; res.err == nil {
+						res.conn.Close()
+					}
+				}()
+			}
+			return res.conn, nil
+		}
+		util.Logf("%v", res.err)
+		errs = append(errs, res.err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// register registers with a relay server in a role and waits until the server pairs it with a host in the other role.
+func register(ctx context.Context, relayAddr, role, channel string) (net.Conn, error) {
+	if channel == "" || strings.ContainsFunc(channel, unicode.IsSpace) || len(channel) > maxChannelLen {
+		return nil, fmt.Errorf("invalid relay channel %q", channel)
+	}
+	relayAddr = hose_net.DefaultPort(relayAddr, Port)
+	util.Logf("connecting to relay %s", relayAddr)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, relayAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Abort waiting when the context is done.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := fmt.Fprintf(conn, "%s %s %s %s %s\n", protocol, version, role, channel, id); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	util.Logf("waiting on relay channel %s", channel)
+	r := bufio.NewReader(conn)
+	reply, err := readLine(r)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("relay %s: %v", relayAddr, ctx.Err())
+		}
+		return nil, fmt.Errorf("relay %s: %v", relayAddr, err)
+	} else if reply != ok {
+		conn.Close()
+		return nil, fmt.Errorf("relay %s: %s", relayAddr, reply)
+	}
+	if !stop() {
+		return nil, fmt.Errorf("relay %s: %v", relayAddr, ctx.Err())
+	}
+	util.Logf("connected through relay %s", relayAddr)
+	return &Conn{conn.(*net.TCPConn), r}, nil
+}
+
+// readLine reads a line of at most maxLineLen bytes, without the newline.
+func readLine(r *bufio.Reader) (string, error) {
+	var line strings.Builder
+	for line.Len() <= maxLineLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		} else if b == '\n' {
+			return line.String(), nil
+		}
+		line.WriteByte(b)
+	}
+	return "", fmt.Errorf("line too long")
+}
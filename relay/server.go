@@ -0,0 +1,190 @@
+package relay
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/util"
+)
+
+// listenTimeout is how long a listener may wait for a host to connect.
+const listenTimeout = 1 * time.Hour
+
+// waiting is a host that is waiting to be paired.
+type waiting struct {
+	conn *net.TCPConn
+	r    *bufio.Reader
+	role string
+	id   string
+	done chan struct{} // closed when the host is no longer watched; see watch.
+}
+
+// server pairs the hosts that register with it.
+type server struct {
+	mu      sync.Mutex
+	waiting map[string][]*waiting // channel -> hosts waiting on the channel.
+}
+
+// Serve runs a relay server on a port. It does not return unless listening fails.
+func Serve(port uint16) error {
+	ln, err := hose_net.Listen(network, port)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	srv := &server{waiting: make(map[string][]*waiting)}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := srv.handle(conn.(*net.TCPConn)); err != nil {
+				util.Logf("%s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// handle reads the registration of a host and pairs it with a waiting host, or makes it wait.
+func (srv *server) handle(conn *net.TCPConn) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	fields := strings.Fields(line)
+	if len(fields) != 5 || fields[0] != protocol {
+		fmt.Fprintf(conn, "malformed registration\n")
+		return fmt.Errorf("malformed registration")
+	} else if fields[1] != version {
+		fmt.Fprintf(conn, "unsupported version %s\n", fields[1])
+		return fmt.Errorf("unsupported version %s", fields[1])
+	}
+	w := &waiting{conn, r, fields[2], fields[4], make(chan struct{})}
+	channel := fields[3]
+	if w.role != listenRole && w.role != connectRole {
+		fmt.Fprintf(conn, "unknown role %s\n", w.role)
+		return fmt.Errorf("unknown role %s", w.role)
+	}
+
+	peer := srv.pair(channel, w)
+	if peer == nil {
+		go srv.watch(channel, w)
+		// Give up if no host arrives in time: connectors expect a listener to be there already.
+		wait, msg := timeout, "no host is listening on channel %s\n"
+		if w.role == listenRole {
+			wait, msg = listenTimeout, "no host connected on channel %s\n"
+		}
+		time.AfterFunc(wait, func() {
+			if srv.remove(channel, w) {
+				fmt.Fprintf(conn, msg, channel)
+				conn.Close()
+			}
+		})
+		return nil
+	}
+	close(w.done) // not watched.
+	peer.unwatch()
+
+	util.Logf("relaying %s <-> %s on channel %s", peer.conn.RemoteAddr(), conn.RemoteAddr(), channel)
+	go forward(peer, w)
+	return nil
+}
+
+// pair removes a host in the other role from a channel and returns it.
+// If there is none, the given host is added to the channel and nil is returned.
+func (srv *server) pair(channel string, w *waiting) *waiting {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	hosts := srv.waiting[channel]
+	for i, other := range hosts {
+		if other.role == w.role || other.id == w.id {
+			continue
+		}
+		srv.waiting[channel] = append(hosts[:i:i], hosts[i+1:]...)
+		if len(srv.waiting[channel]) == 0 {
+			delete(srv.waiting, channel)
+		}
+		return other
+	}
+	srv.waiting[channel] = append(hosts, w)
+	return nil
+}
+
+// remove removes a waiting host from a channel. It reports whether the host was waiting.
+func (srv *server) remove(channel string, w *waiting) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	hosts := srv.waiting[channel]
+	for i, other := range hosts {
+		if other == w {
+			srv.waiting[channel] = append(hosts[:i:i], hosts[i+1:]...)
+			if len(srv.waiting[channel]) == 0 {
+				delete(srv.waiting, channel)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// watch waits until a waiting host closes its connection, and then removes it from its channel.
+// Hosts send nothing before they are paired, so the connection is only readable once it is closed.
+// Watching stops when unwatch is called because the host was paired.
+func (srv *server) watch(channel string, w *waiting) {
+	defer close(w.done)
+	_, err := w.r.Peek(1)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return // paired.
+	}
+	if srv.remove(channel, w) {
+		util.Logf("%s left channel %s", w.conn.RemoteAddr(), channel)
+		w.conn.Close()
+	}
+}
+
+// unwatch stops watching a host that was paired, so that its connection can be read by forward.
+func (w *waiting) unwatch() {
+	w.conn.SetReadDeadline(time.Now())
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+}
+
+// forward tells two hosts that they are paired and forwards the bytes between them until both are done.
+func forward(a, b *waiting) {
+	defer a.conn.Close()
+	defer b.conn.Close()
+	if _, err := fmt.Fprintf(a.conn, "%s\n", ok); err != nil {
+		return
+	}
+	if _, err := fmt.Fprintf(b.conn, "%s\n", ok); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(b.conn, a.r)
+		b.conn.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(a.conn, b.r)
+		a.conn.CloseWrite()
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"git.samanthony.xyz/hose/relay"
+)
+
+const relayUsage = "Usage: hose relay [-port <port>]"
+
+// relayCmd runs a relay server that forwards connections between hosts that cannot reach each other directly.
+func relayCmd(args []string) error {
+	flags := flag.NewFlagSet("relay", flag.ExitOnError)
+	port := flags.Uint("port", relay.Port, "TCP port to listen on")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(relayUsage)
+	} else if *port < 1 || *port > 65535 {
+		return fmt.Errorf("invalid port %d", *port)
+	}
+	return relay.Serve(uint16(*port))
+}
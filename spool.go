@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"github.com/tonistiigi/units"
+	"io"
+	"net"
+	"time"
+
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/inbox"
+	hose_net "git.samanthony.xyz/hose/net"
+	"git.samanthony.xyz/hose/relay"
+	"git.samanthony.xyz/hose/util"
+)
+
+const (
+	// spoolTimeout is how long a sender may take to start a transfer that is saved in the inbox.
+	spoolTimeout = time.Minute
+
+	// maxSpooling is the maximum number of transfers that are received into the inbox at once.
+	maxSpooling = 32
+)
+
+// spoolTransfers receives transfers until it is killed, and saves each one in the inbox.
+// Transfers are received concurrently, up to maxSpooling at once; an error in one does not stop the others.
+func spoolTransfers() error {
+	if *connectHost != "" || *relayAddr != "" {
+		return errors.New("cannot spool transfers with -connect or -relay")
+	}
+
+	// Let senders find this host on the local network.
+	if *advertise {
+		if adv, err := advertiseSelf(); err != nil {
+			util.Logf("not advertising on the local network: %v", err)
+		} else {
+			defer adv.Close()
+		}
+	}
+
+	ln, err := hose_net.Listen(network, uint16(*listenPort))
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	util.Logf("saving received transfers in the inbox")
+	slots := make(chan struct{}, maxSpooling)
+	for {
+		slots <- struct{}{} // wait for a transfer to finish if there are too many.
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer func() { <-slots }()
+			defer conn.Close()
+			if err := spoolTransfer(conn); err != nil {
+				util.Logf("%s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// spoolTransfer receives a transfer and saves it in the inbox once it has been completely received and verified.
+func spoolTransfer(conn net.Conn) error {
+	util.Logf("accepted connection from %s", conn.RemoteAddr())
+
+	// Load the keys for every transfer, so that hosts added in the meantime are known.
+	keyring, err := loadKeyring()
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(spoolTimeout))
+	host, plaintext, err := openTransfer(conn, keyring)
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	w, err := inbox.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, plaintext); err != nil {
+		w.Discard()
+		return err
+	}
+	item := inbox.Item{From: host.Name, Fingerprint: host.Fingerprint(), Received: time.Now()}
+	if raddr, err := remoteAddr(conn); err == nil && !relay.Relayed(conn) {
+		item.Addr = raddr.String()
+	}
+	item, err = w.Commit(item)
+	if err != nil {
+		return err
+	}
+	util.Logf("received %#.2f from %s as item %d", units.Bytes(item.Size)*units.B, host.Name, item.ID)
+	return hosts.Seen(host.Name)
+}
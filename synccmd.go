@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"git.samanthony.xyz/hose/hostsync"
+)
+
+const syncUsage = "Usage: hose sync <serve [-port <port>] [-replace] | connect [-port <port>] [-replace] <device>>"
+
+// syncCommands are the subcommands of "hose sync".
+var syncCommands = map[string]func(args []string) error{
+	"serve":   syncServe,
+	"connect": syncConnect,
+}
+
+// syncCmd syncs the known hosts with another device of the user.
+func syncCmd(args []string) error {
+	if len(args) < 1 {
+		return errors.New(syncUsage)
+	}
+	cmd, ok := syncCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], syncUsage)
+	}
+	return cmd(args[1:])
+}
+
+// syncServe waits for another device to connect and syncs the known hosts with it.
+func syncServe(args []string) error {
+	flags := flag.NewFlagSet("sync serve", flag.ExitOnError)
+	port := flags.Uint("port", hostsync.Port, "TCP port to sync on")
+	replace := flags.Bool("replace", false, "accept the other device's keys for hosts whose keys conflict")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New(syncUsage)
+	} else if err := checkPort(*port); err != nil {
+		return err
+	}
+	return hostsync.Serve(uint16(*port), *replace)
+}
+
+// syncConnect connects to another device and syncs the known hosts with it.
+func syncConnect(args []string) error {
+	flags := flag.NewFlagSet("sync connect", flag.ExitOnError)
+	port := flags.Uint("port", hostsync.Port, "TCP port to sync on")
+	replace := flags.Bool("replace", false, "accept the other device's keys for hosts whose keys conflict")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New(syncUsage)
+	} else if err := checkPort(*port); err != nil {
+		return err
+	}
+	return hostsync.Sync(flags.Arg(0), uint16(*port), *replace)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// detach does nothing on systems without sessions.
+func detach(cmd *exec.Cmd) {}
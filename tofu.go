@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"git.samanthony.xyz/hose/hosts"
+	"git.samanthony.xyz/hose/identity"
+	"git.samanthony.xyz/hose/key"
+	"git.samanthony.xyz/hose/relay"
+	"git.samanthony.xyz/hose/util"
+)
+
+// announceTimeout is how long a sender waits for the receiver to announce itself.
+const announceTimeout = 10 * time.Second
+
+// announce announces the identity of the local host to a remote host.
+// If reply is true, the remote host is asked to announce itself in return.
+func announce(conn net.Conn, reply bool) error {
+	id, err := identity.Local(nil)
+	if err != nil {
+		return err
+	}
+	return identity.Announce(conn, identity.Announcement{Identity: id, Reply: reply})
+}
+
+// learnReceiver waits for the receiver to announce itself and trusts its keys on first use.
+// Rhost is the name or address that the receiver was contacted by.
+func learnReceiver(conn net.Conn, rhost string) (hosts.Host, error) {
+	conn.SetReadDeadline(time.Now().Add(announceTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	a, ok, err := identity.ReadAnnouncement(bufio.NewReader(conn))
+	if err != nil {
+		return hosts.Host{}, err
+	} else if !ok {
+		return hosts.Host{}, fmt.Errorf("%s did not announce its keys; is it receiving with -tofu?", rhost)
+	}
+	return trustHost(a.Identity, rhost)
+}
+
+// trustSender trusts the keys announced by an unknown sender on first use.
+// The key that signed the stream must be the one that the sender announced.
+func trustSender(a identity.Announcement, conn net.Conn, sigPubKey key.SigPublicKey) (hosts.Host, error) {
+	if sigPubKey != a.SigPublicKey {
+		return hosts.Host{}, fmt.Errorf("sender signed with a key other than the one it announced")
+	}
+	if relay.Relayed(conn) {
+		return trustHost(a.Identity, "") // the address is the relay's.
+	}
+	raddr, err := remoteAddr(conn)
+	if err != nil {
+		return hosts.Host{}, err
+	}
+	return trustHost(a.Identity, raddr.String())
+}
+
+// trustHost saves the keys of a host that was contacted for the first time, without asking the user to verify them.
+// The host is saved under the name it announced, or else under the address it was contacted at.
+// It is refused if a known host already has that name, or is known at that address, with different keys.
+// If the host is already known by that name with the same keys, its aliases and addresses are kept.
+// Addr is empty if the host's address is not known, e.g. if it connected through a relay server.
+func trustHost(id identity.Identity, addr string) (hosts.Host, error) {
+	name := id.Name
+	if name == "" {
+		name = addr
+	}
+	if name == "" {
+		return hosts.Host{}, fmt.Errorf("host did not announce a name")
+	}
+	host := id.Host(name)
+	if addr != "" {
+		host.Addrs = []string{addr}
+	}
+	host.Trust = hosts.TOFU
+
+	err := hosts.CheckKeys(host, addr)
+	var keyChange *hosts.KeyChangeError
+	if errors.As(err, &keyChange) {
+		util.Logf("%s", keyChange.Warning())
+		return hosts.Host{}, fmt.Errorf("%v; refusing to trust them", err)
+	} else if err != nil {
+		return hosts.Host{}, err
+	}
+	known, err := keepKnownNames(&host)
+	if err != nil {
+		return hosts.Host{}, err
+	}
+
+	err = hosts.Add(host)
+	if errors.As(err, &keyChange) {
+		util.Logf("%s", keyChange.Warning())
+		return hosts.Host{}, fmt.Errorf("%v; refusing to trust them", err)
+	} else if err != nil {
+		return hosts.Host{}, err
+	}
+	if !known {
+		util.Logf("trusting keys of new host %q on first use; fingerprint: %s", host.Name, host.Fingerprint())
+	}
+	return host, nil
+}
+
+// keepKnownNames keeps the aliases and addresses of a known host with the same name.
+// It reports whether the host is known.
+func keepKnownNames(host *hosts.Host) (bool, error) {
+	old, err := hosts.Lookup(host.Name)
+	if errors.Is(err, hosts.ErrNoSuchHost) || (err == nil && !old.HasName(host.Name)) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	host.Aliases = old.Aliases
+	for _, addr := range old.Addrs {
+		if !slices.Contains(host.Addrs, addr) {
+			host.Addrs = append(host.Addrs, addr)
+		}
+	}
+	return true, nil
+}
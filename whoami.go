@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"git.samanthony.xyz/hose/fingerprint"
+	"git.samanthony.xyz/hose/identity"
+	"git.samanthony.xyz/hose/profile"
+)
+
+// whoami prints the local identity: its name, public keys and fingerprint.
+// Remote users compare the fingerprint with the one they see during a handshake.
+// With -qr, it also prints the identity as a QR code and as text,
+// which a remote user can import with "hose handshake -import".
+func whoami(args []string) error {
+	flags := flag.NewFlagSet("whoami", flag.ExitOnError)
+	var format fingerprint.Format
+	flags.Var(&format, "fingerprint", "fingerprint format: hex, words, emoji or art")
+	qrFlag := flags.Bool("qr", false, "print the identity as a QR code to import with hose handshake -import")
+	addrFlag := flags.String("addr", "", "comma-separated addresses to include in the QR code (default: the addresses of the network interfaces)")
+	flags.Parse(args)
+
+	addrs := splitList(*addrFlag)
+	if *qrFlag && len(addrs) < 1 {
+		var err error
+		addrs, err = identity.LocalAddrs()
+		if err != nil {
+			return err
+		}
+	}
+	id, err := identity.Local(addrs)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "identity:\t%s\n", profile.Name())
+	fmt.Fprintf(w, "name:\t%s\n", orDash(id.Name))
+	fmt.Fprintf(w, "encryption key:\t%x\n", id.BoxPublicKey)
+	fmt.Fprintf(w, "signature key:\t%x\n", id.SigPublicKey)
+	printFingerprint(w, fingerprint.Of(id.BoxPublicKey, id.SigPublicKey), format)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if *qrFlag {
+		fmt.Println()
+		if err := printQR(os.Stdout, id.String()); err != nil {
+			return err
+		}
+		fmt.Println(id)
+	}
+	return nil
+}